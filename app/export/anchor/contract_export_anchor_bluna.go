@@ -12,6 +12,7 @@ import (
 
 // ExportbLUNA get bLUNA provided to anchor as collateral.
 // ER conversion is taken later in lido exporter
+// With NetBorrowerPositions, outstanding loans are subtracted from the collateral
 func ExportbLUNA(app *app.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
 	bl.RegisterAddress(util.DenomLUNA, AddressBLUNAHub)
 	bl.RegisterAddress(util.DenomBLUNA, AddressBLUNACustody)
@@ -55,6 +56,12 @@ func ExportbLUNA(app *app.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggr
 		return false
 	})
 
+	if NetBorrowerPositions {
+		if err := NetBorrowerLoans(app, finalBalance); err != nil {
+			return nil, err
+		}
+	}
+
 	// exchange rate is handled in lido code
 	return finalBalance, nil
 }
@@ -0,0 +1,183 @@
+package anchor
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+	"github.com/terra-money/core/x/wasm/types"
+)
+
+var (
+	// NetBorrowerPositions subtracts outstanding loans from the bLUNA collateral credit.
+	// Set with the anchor-net-borrowers flag.
+	NetBorrowerPositions = false
+	// LunaPrice overrides the x/oracle LUNA/UST exchange rate used to value loans, nil uses the oracle.
+	// Set with the anchor-luna-price flag.
+	LunaPrice *sdk.Dec
+)
+
+type borrowerInfo struct {
+	Borrower   string  `json:"borrower"`
+	LoanAmount sdk.Int `json:"loan_amount"`
+}
+
+// NetBorrowerLoans subtracts each borrower's outstanding loan from its bLUNA collateral. The loan is
+// spread over all collaterals of the borrower pro-rata to their value, only the bLUNA part is netted.
+// bLUNA is valued at LunaPrice, or at the x/oracle LUNA/UST exchange rate at the snapshot height,
+// other collaterals at the Anchor oracle price.
+func NetBorrowerLoans(app *app.TerraApp, collaterals util.SnapshotBalanceAggregateMap) error {
+	app.Logger().Info("Netting Anchor loans against bLUNA collateral")
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+
+	lunaPrice, err := getLunaPrice(app)
+	if err != nil {
+		return err
+	}
+	blunaExchangeRate, err := getBLunaExchangeRate(ctx, q)
+	if err != nil {
+		return err
+	}
+	// uusd => bLUNA
+	ustToBLuna := sdk.OneDec().Quo(lunaPrice).Quo(blunaExchangeRate)
+	oracle, err := getOracleContract(ctx, q)
+	if err != nil {
+		return err
+	}
+	prices := map[string]sdk.Dec{
+		util.AddressBLUNA: lunaPrice.Mul(blunaExchangeRate),
+	}
+
+	var data [][]string
+	totalNetted := sdk.ZeroInt()
+	for addr, sbs := range collaterals {
+		var info borrowerInfo
+		if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+			ContractAddress: MoneyMarketContract,
+			QueryMsg:        []byte(fmt.Sprintf("{\"borrower_info\":{\"borrower\":\"%s\",\"block_height\":%d}}", addr, app.LastBlockHeight())),
+		}, &info); err != nil {
+			return fmt.Errorf("unable to query borrower info of %s: %v", addr, err)
+		}
+		if info.LoanAmount.IsNil() || info.LoanAmount.IsZero() {
+			continue
+		}
+
+		blunaLoan, err := getBLunaLoanShare(ctx, q, oracle, prices, addr, info.LoanAmount)
+		if err != nil {
+			return err
+		}
+		loanInBLuna := ustToBLuna.MulInt(blunaLoan).Ceil().TruncateInt()
+		for i, sb := range sbs {
+			if sb.Denom != util.DenomBLUNA {
+				continue
+			}
+			net := sb.Balance.Sub(loanInBLuna)
+			if net.IsNegative() {
+				net = sdk.ZeroInt()
+			}
+			totalNetted = totalNetted.Add(sb.Balance.Sub(net))
+			data = append(data, []string{addr, sb.Balance.String(), info.LoanAmount.String(), blunaLoan.String(), loanInBLuna.String(), net.String()})
+			sbs[i].Balance = net
+		}
+	}
+	app.Logger().Info(fmt.Sprintf("... netted %s bLUNA of loans at %s uusd/uluna", totalNetted, lunaPrice))
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/anchor-borrowers.csv", folder), []string{"borrower", "bluna_collateral", "loan_uusd", "bluna_loan_uusd", "loan_bluna", "net_bluna"}, data)
+
+	return nil
+}
+
+// getBLunaLoanShare returns the part of a loan backed by bLUNA, pro-rata to the uusd value of
+// the borrower's collaterals. prices caches uusd prices per collateral token.
+func getBLunaLoanShare(ctx context.Context, q types.QueryServer, oracle string, prices map[string]sdk.Dec, borrower string, loan sdk.Int) (sdk.Int, error) {
+	var res struct {
+		Collaterals [][2]string `json:"collaterals"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: AddressOverseer,
+		QueryMsg:        []byte(fmt.Sprintf("{\"collaterals\":{\"borrower\":\"%s\"}}", borrower)),
+	}, &res); err != nil {
+		return sdk.Int{}, fmt.Errorf("unable to query collaterals of %s: %v", borrower, err)
+	}
+
+	total := sdk.ZeroDec()
+	bluna := sdk.ZeroDec()
+	for _, collateral := range res.Collaterals {
+		token := collateral[0]
+		amount, ok := sdk.NewIntFromString(collateral[1])
+		if !ok {
+			return sdk.Int{}, fmt.Errorf("invalid %s collateral amount %s of %s", token, collateral[1], borrower)
+		}
+		price, ok := prices[token]
+		if !ok {
+			var err error
+			if price, err = getOraclePrice(ctx, q, oracle, token); err != nil {
+				return sdk.Int{}, err
+			}
+			prices[token] = price
+		}
+		value := price.MulInt(amount)
+		total = total.Add(value)
+		if token == util.AddressBLUNA {
+			bluna = bluna.Add(value)
+		}
+	}
+	if !total.IsPositive() {
+		return loan, nil
+	}
+	return bluna.MulInt(loan).Quo(total).Ceil().TruncateInt(), nil
+}
+
+func getOracleContract(ctx context.Context, q types.QueryServer) (string, error) {
+	var config struct {
+		OracleContract string `json:"oracle_contract"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: AddressOverseer,
+		QueryMsg:        []byte("{\"config\":{}}"),
+	}, &config); err != nil {
+		return "", err
+	}
+	return config.OracleContract, nil
+}
+
+// getOraclePrice returns the uusd price of a collateral token from the Anchor oracle
+func getOraclePrice(ctx context.Context, q types.QueryServer, oracle string, token string) (sdk.Dec, error) {
+	var price struct {
+		Rate sdk.Dec `json:"rate"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: oracle,
+		QueryMsg:        []byte(fmt.Sprintf("{\"price\":{\"base\":\"%s\",\"quote\":\"uusd\"}}", token)),
+	}, &price); err != nil {
+		return sdk.Dec{}, fmt.Errorf("unable to get price of %s: %v", token, err)
+	}
+	return price.Rate, nil
+}
+
+func getLunaPrice(app *app.TerraApp) (sdk.Dec, error) {
+	if LunaPrice != nil {
+		return *LunaPrice, nil
+	}
+	ctx := util.PrepCtx(app)
+	return app.OracleKeeper.GetLunaExchangeRate(sdk.UnwrapSDKContext(ctx), util.DenomUST)
+}
+
+func getBLunaExchangeRate(ctx context.Context, q types.QueryServer) (sdk.Dec, error) {
+	var state struct {
+		BLunaExchangeRate sdk.Dec `json:"bluna_exchange_rate"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: AddressBLUNAHub,
+		QueryMsg:        []byte("{\"state\":{}}"),
+	}, &state); err != nil {
+		return sdk.Dec{}, err
+	}
+	return state.BLunaExchangeRate, nil
+}
@@ -0,0 +1,152 @@
+package anchor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+	"github.com/terra-money/core/x/wasm/types"
+)
+
+// whitelistPageSize is the number of overseer whitelist entries fetched per query
+const whitelistPageSize = 30
+
+// ExportNonTargetCollaterals returns every overseer collateral other than bLUNA (bETH, wasAVAX, bATOM, ...)
+// per user, with the collateral token as denom. Nothing is merged into the snapshot.
+func ExportNonTargetCollaterals(app *app.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+	app.Logger().Info("Exporting Anchor non-target collaterals")
+	ctx := util.PrepCtx(app)
+	keeper := app.WasmKeeper
+	overseer := util.ToAddress(AddressOverseer)
+
+	holdings := make(util.SnapshotBalanceAggregateMap)
+	var collaterals = make([][2]string, 0)
+	var err error
+	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), overseer, util.GeneratePrefix("collateral"), func(key, value []byte) bool {
+		userAddr := sdk.AccAddress(key).String()
+		if err = json.Unmarshal(value, &collaterals); err != nil {
+			err = fmt.Errorf("err while fetching collaterals: %v", err)
+			return true
+		}
+
+		for _, collateral := range collaterals {
+			bz, _ := base64.StdEncoding.DecodeString(collateral[0])
+			assetAddr := sdk.AccAddress(bz).String()
+			if assetAddr == util.AddressBLUNA {
+				continue
+			}
+
+			balance, _ := sdk.NewIntFromString(collateral[1])
+			holdings.AppendOrAddBalance(userAddr, util.SnapshotBalance{
+				Denom:   assetAddr,
+				Balance: balance,
+			})
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return holdings, nil
+}
+
+// ReportNonTargetCollaterals writes the non-target collaterals per user, valued at the Anchor oracle price,
+// for governance review. Rows are sorted by asset, then user.
+func ReportNonTargetCollaterals(app *app.TerraApp, holdings util.SnapshotBalanceAggregateMap) error {
+	app.Logger().Info("Reporting Anchor non-target collaterals")
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+
+	oracle, err := getOracleContract(ctx, q)
+	if err != nil {
+		return err
+	}
+	symbols, err := getWhitelistSymbols(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	// asset => user => amount
+	byAsset := make(map[string]map[string]sdk.Int)
+	for user, sbs := range holdings {
+		for _, sb := range sbs {
+			if byAsset[sb.Denom] == nil {
+				byAsset[sb.Denom] = make(map[string]sdk.Int)
+			}
+			byAsset[sb.Denom][user] = sb.Balance
+		}
+	}
+
+	var assets []string
+	for asset := range byAsset {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	var data [][]string
+	for _, asset := range assets {
+		price, err := getOraclePrice(ctx, q, oracle, asset)
+		if err != nil {
+			return err
+		}
+
+		var users []string
+		for user := range byAsset[asset] {
+			users = append(users, user)
+		}
+		sort.Strings(users)
+
+		total := sdk.ZeroInt()
+		for _, user := range users {
+			amount := byAsset[asset][user]
+			value := price.MulInt(amount).TruncateInt()
+			data = append(data, []string{user, asset, symbols[asset], amount.String(), price.String(), value.String()})
+			total = total.Add(amount)
+		}
+		app.Logger().Info(fmt.Sprintf("... %s %s in %d positions, %s uusd", total, symbols[asset], len(users), price.MulInt(total).TruncateInt()))
+	}
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/anchor-non-target-collaterals.csv", folder), []string{"user", "asset", "symbol", "amount", "price", "value_uusd"}, data)
+
+	return nil
+}
+
+// getWhitelistSymbols returns collateral token => symbol for the whole overseer whitelist
+func getWhitelistSymbols(ctx context.Context, q types.QueryServer) (map[string]string, error) {
+	symbols := make(map[string]string)
+	startAfter := ""
+	for {
+		query := fmt.Sprintf("{\"whitelist\":{\"limit\":%d}}", whitelistPageSize)
+		if startAfter != "" {
+			query = fmt.Sprintf("{\"whitelist\":{\"start_after\":\"%s\",\"limit\":%d}}", startAfter, whitelistPageSize)
+		}
+
+		var whitelist struct {
+			Elems []struct {
+				Symbol          string `json:"symbol"`
+				CollateralToken string `json:"collateral_token"`
+			} `json:"elems"`
+		}
+		if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+			ContractAddress: AddressOverseer,
+			QueryMsg:        []byte(query),
+		}, &whitelist); err != nil {
+			return nil, err
+		}
+		for _, elem := range whitelist.Elems {
+			symbols[elem.CollateralToken] = elem.Symbol
+		}
+		if len(whitelist.Elems) < whitelistPageSize {
+			return symbols, nil
+		}
+		startAfter = whitelist.Elems[len(whitelist.Elems)-1].CollateralToken
+	}
+}
@@ -3,7 +3,12 @@ package aperture
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	log "github.com/tendermint/tendermint/libs/log"
@@ -16,6 +21,16 @@ var (
 	apertureManager             = "terra1ajkmy2c0g84seh66apv9x6xt6kd3ag80jmcvtz"
 	apertureDeltaNeutralManager = "terra1jvehz6d9gk3gl4tldrzd8qzj8zfkurfvtcg99x"
 	positionContractAddress     = "terra1aaxjgzems5gm07mg6fa50mjxjevsx4gsk6np5j"
+
+	// WorkerCount is the number of goroutines querying positions
+	WorkerCount = 5
+	// AllowFailedPositions exports without positions whose query fails, instead of failing
+	// the export. Failed positions are listed in aperture-failed.csv.
+	AllowFailedPositions = false
+	// MaxRetries is the number of times failed positions are queried again once all workers are done
+	MaxRetries = 3
+	// RetryBackoff is the wait before the first retry of a position, doubled on every retry
+	RetryBackoff = time.Second
 )
 
 type BatchResponse struct {
@@ -34,19 +49,27 @@ type BatchItem struct {
 		} `json:"position_close_info"`
 		DetailedInfo struct {
 			State struct {
-				AUstAmount sdk.Int `json:"collateral_anchor_ust_amount"`
+				AUstAmount  sdk.Int `json:"collateral_anchor_ust_amount"`
+				ShortAmount sdk.Int `json:"mirror_asset_short_amount"`
 			} `json:"state"`
 			UstAmount sdk.Int `json:"uusd_value"`
 		} `json:"detailed_info"`
 	} `json:"info"`
 }
 
-func ExportApertureVaultsPreAttack(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
-	return exportApertureVaults(app, util.Snapshot(util.PreAttack), bl)
+type positionResult struct {
+	PositionId int64
+	Items      []BatchItem
+	Err        error
+	Attempts   int
 }
 
-func ExportApertureVaultsPostAttack(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
-	return exportApertureVaults(app, util.Snapshot(util.PostAttack), bl)
+// ExportContract returns the aperture export for a snapshot type. Pre-attack credits the aUST
+// collateral of open positions, post-attack credits their UST value.
+func ExportContract(snapshotType util.Snapshot) func(*terra.TerraApp, util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+	return func(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+		return exportApertureVaults(app, snapshotType, bl)
+	}
 }
 
 func exportApertureVaults(app *terra.TerraApp, snapshotType util.Snapshot, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
@@ -57,22 +80,49 @@ func exportApertureVaults(app *terra.TerraApp, snapshotType util.Snapshot, bl ut
 	if err != nil {
 		return nil, err
 	}
-	workerCount := 5
-	jobs := make(chan (sdk.Int), lastPosition.Int64())
-	for j := int64(0); j < lastPosition.Int64(); j++ {
-		jobs <- sdk.NewInt(j)
+	total := lastPosition.Int64()
+	jobs := make(chan (int64), total)
+	for j := int64(0); j < total; j++ {
+		jobs <- j
 	}
 	close(jobs)
-	items := make(chan (BatchItem), lastPosition.Int64())
+	results := make(chan (positionResult), total)
+	var done, failed int64
 	wg := sync.WaitGroup{}
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < WorkerCount; i++ {
 		wg.Add(1)
-		go worker(&wg, app.Logger(), ctx, q, jobs, items)
+		go worker(&wg, app.Logger(), ctx, q, jobs, results, total, &done, &failed)
 	}
 	wg.Wait()
-	close(items)
+	close(results)
+
+	var items []BatchItem
+	var failures []positionResult
+	for result := range results {
+		if result.Err != nil {
+			failures = append(failures, result)
+			continue
+		}
+		items = append(items, result.Items...)
+	}
+
+	// transient failures get another chance, one position at a time
+	var retried []BatchItem
+	retried, failures = retryFailures(app.Logger(), ctx, q, failures)
+	items = append(items, retried...)
+	app.Logger().Info(fmt.Sprintf("... %d open aperture positions, %d positions failed", len(items), len(failures)))
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	writeFailures(folder, failures)
+	if len(failures) > 0 && !AllowFailedPositions {
+		return nil, fmt.Errorf("%d aperture positions failed, first: position %d: %v",
+			len(failures), failures[0].PositionId, failures[0].Err)
+	}
+	writePositions(folder, items)
+
 	snapshot := make(util.SnapshotBalanceAggregateMap)
-	for item := range items {
+	for _, item := range items {
 		// Avoid double counting by only taking aUST amount for pre-attack snapshot
 		// UST amount in aperture is a "virtual" amount as the UST is converted to aUST and used
 		// as collateral in mirror. The UST amount field is a calculated field for the final UST amount
@@ -108,38 +158,104 @@ func getApertureLastPositionId(ctx context.Context, q wasmtypes.QueryServer) (sd
 	return nextPositionResponse.NextPosition, nil
 }
 
-func worker(wg *sync.WaitGroup, log log.Logger, ctx context.Context, q wasmtypes.QueryServer, jobs <-chan (sdk.Int), results chan<- (BatchItem)) {
+func worker(wg *sync.WaitGroup, log log.Logger, ctx context.Context, q wasmtypes.QueryServer, jobs <-chan (int64), results chan<- (positionResult), total int64, done, failed *int64) {
 	defer wg.Done()
 	for j := range jobs {
-		err := getApertureOpenPositions(log, ctx, q, j, results)
-		if err != nil {
-			panic(err)
+		result := positionResult{PositionId: j, Attempts: 1}
+		result.Items, result.Err = getApertureOpenPositions(ctx, q, j)
+		if result.Err != nil {
+			atomic.AddInt64(failed, 1)
+		}
+		results <- result
+		if n := atomic.AddInt64(done, 1); n%500 == 0 || n == total {
+			log.Info(fmt.Sprintf("... Position %d / %d, %d failed", n, total, atomic.LoadInt64(failed)))
 		}
 	}
 }
 
-func getApertureOpenPositions(log log.Logger, ctx context.Context, q wasmtypes.QueryServer, positionId sdk.Int, results chan<- (BatchItem)) error {
-	if positionId.ModRaw(500).IsZero() {
-		log.Info(fmt.Sprintf("... Position %s", positionId))
+// retryFailures queries failed positions again, sequentially with an exponential backoff,
+// up to MaxRetries times each. Returns the items of recovered positions and the remaining failures.
+func retryFailures(log log.Logger, ctx context.Context, q wasmtypes.QueryServer, failures []positionResult) ([]BatchItem, []positionResult) {
+	if len(failures) == 0 {
+		return nil, nil
+	}
+	log.Info(fmt.Sprintf("... retrying %d failed positions", len(failures)))
+
+	var items []BatchItem
+	var remaining []positionResult
+	for _, failure := range failures {
+		backoff := RetryBackoff
+		for failure.Err != nil && failure.Attempts <= MaxRetries {
+			time.Sleep(backoff)
+			backoff *= 2
+			failure.Attempts++
+			failure.Items, failure.Err = getApertureOpenPositions(ctx, q, failure.PositionId)
+		}
+		if failure.Err != nil {
+			remaining = append(remaining, failure)
+			continue
+		}
+		items = append(items, failure.Items...)
 	}
+	log.Info(fmt.Sprintf("... %d positions recovered, %d still failing", len(failures)-len(remaining), len(remaining)))
+	return items, remaining
+}
+
+func getApertureOpenPositions(ctx context.Context, q wasmtypes.QueryServer, positionId int64) ([]BatchItem, error) {
 	var batchResponse BatchResponse
-	positionQuery := fmt.Sprintf("{\"position_id\":\"%s\", \"chain_id\": 3 }", positionId)
+	positionQuery := fmt.Sprintf("{\"position_id\":\"%d\", \"chain_id\": 3 }", positionId)
 	query := fmt.Sprintf("{\"batch_get_position_info\": {\"positions\": [%s]}}", positionQuery)
-	// fmt.Println(query)
 	err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
 		ContractAddress: apertureDeltaNeutralManager,
 		QueryMsg:        []byte(query),
 	}, &batchResponse)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var open []BatchItem
 	for _, item := range batchResponse.Items {
 		if item.Info.PositionCloseInfo.Height == 0 {
-			results <- item
+			open = append(open, item)
 		}
 	}
-	if err != nil {
-		panic(err)
+	return open, nil
+}
+
+// writePositions writes the breakdown of every open position to aperture-positions.csv
+func writePositions(folder string, items []BatchItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Contract < items[j].Contract
+	})
+	orZero := func(i sdk.Int) string {
+		if i.IsNil() {
+			return "0"
+		}
+		return i.String()
+	}
+	var data [][]string
+	for _, item := range items {
+		data = append(data, []string{
+			item.Holder,
+			item.Contract,
+			strconv.Itoa(item.Info.PositionOpenInfo.Height),
+			orZero(item.Info.DetailedInfo.State.AUstAmount),
+			orZero(item.Info.DetailedInfo.UstAmount),
+			orZero(item.Info.DetailedInfo.State.ShortAmount),
+		})
+	}
+	util.ToCsv(fmt.Sprintf("%s/aperture-positions.csv", folder), []string{"holder", "contract", "open_height", "aust_collateral", "uusd_value", "masset_short"}, data)
+}
+
+func writeFailures(folder string, failures []positionResult) {
+	if len(failures) == 0 {
+		return
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].PositionId < failures[j].PositionId
+	})
+	var data [][]string
+	for _, failure := range failures {
+		data = append(data, []string{strconv.FormatInt(failure.PositionId, 10), strconv.Itoa(failure.Attempts), failure.Err.Error()})
 	}
-	return nil
+	util.ToCsv(fmt.Sprintf("%s/aperture-failed.csv", folder), []string{"position_id", "attempts", "error"}, data)
 }
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
+	"sort"
 
 	"github.com/cosmos/cosmos-sdk/types"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/astroport"
+	"github.com/terra-money/core/app/export/dex"
 	util "github.com/terra-money/core/app/export/util"
 	"github.com/terra-money/core/x/wasm/keeper"
 	wasmtypes "github.com/terra-money/core/x/wasm/types"
@@ -21,12 +25,27 @@ var (
 	astroportLockdrop  = "terra1627ldjvxatt54ydd3ns6xaxtd68a2vtyu7kakj"
 	apolloUstAstroLp   = "terra1zuktmswe9zjck0xdpw2k79t0crjk86fljv2rm0"
 	apolloToken        = "terra100yeqvww74h4yaejj6h733thgcafdaukjtw397"
+
+	astroStaticStrategy     = "terra1x7v7qvumfl36g5jh0mtqx3c4g8c35sn0sqfuqp"
+	terraswapStaticStrategy = "terra14ge98vxgp3ey90d38wwk9xu73wydjz8vd66h3f"
+
+	// number of stakers and cfe accounts whose decoded values are checked with smart queries
+	VaultRewardsSampleSize = 200
 )
 
 type Strategy struct {
 	Address string `json:"address"`
 }
 
+// factoryStrategy is a strategy entry of the factory with its APOLLO emission, distributed
+// over block heights: every schedule is a (start, end, amount) tuple
+type factoryStrategy struct {
+	GlobalIndex          sdk.Dec              `json:"global_index"`
+	TotalBondAmount      types.Int            `json:"total_bond_amount"`
+	LastDistributed      uint64               `json:"last_distributed"`
+	DistributionSchedule [][3]json.RawMessage `json:"distribution_schedule"`
+}
+
 type StrategyInfo struct {
 	TotalBondAmount types.Int `json:"total_bond_amount"`
 	TotalShares     types.Int `json:"total_shares"`
@@ -50,6 +69,19 @@ type StakerInfoResponse struct {
 	PendingReward types.Int `json:"pending_reward"`
 }
 
+// lmRewardInfo is a staker's entry in the factory lm_rewards map
+type lmRewardInfo struct {
+	RewardIndex   sdk.Dec   `json:"reward_index"`
+	BondAmount    types.Int `json:"bond_amount"`
+	PendingReward types.Int `json:"pending_reward"`
+}
+
+type lmRewardEntry struct {
+	WalletAddr string
+	StrategyId string
+	Info       lmRewardInfo
+}
+
 type GetTotalCfeRewardsResponse struct {
 	PendingReward          types.Int `json:"pending_reward"`
 	ExtensionPendingReward types.Int `json:"extension_pending_reward"`
@@ -64,6 +96,14 @@ type CfeAccountInfoResponse struct {
 	LastClaimedPhase2             int       `json:"last_claimed_phase2"`
 }
 
+// cfeConfig is the vesting schedule of both CFE phases, in seconds
+type cfeConfig struct {
+	Phase1Start uint64 `json:"phase1_start"`
+	Phase1End   uint64 `json:"phase1_end"`
+	Phase2Start uint64 `json:"phase2_start"`
+	Phase2End   uint64 `json:"phase2_end"`
+}
+
 type CfeAccountResponse struct {
 	Address string                 `json:"address"`
 	Info    CfeAccountInfoResponse `json:"info"`
@@ -130,7 +170,7 @@ func ExportApolloVaultLPs(app *terra.TerraApp, snapshot util.SnapshotBalanceAggr
 	for _, strat := range strats {
 		lpHoldings, lpTokenAddr, err := getLpHoldingsForStrat(ctx, app.WasmKeeper, strat)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		allLpHoldings[strat.String()] = make(map[string]map[string]sdk.Int)
 		allLpHoldings[strat.String()][lpTokenAddr.String()] = lpHoldings
@@ -150,54 +190,11 @@ func ExportApolloUsers(app *terra.TerraApp) ([]sdk.AccAddress, error) {
 func ExportVaultRewards(app *terra.TerraApp) ([]AddressWithBalance, error) {
 	app.Logger().Info("Exporting Apollo Vault Rewards")
 	ctx := util.PrepCtx(app)
-	qs := util.PrepWasmQueryServer(app)
 	keeper := app.WasmKeeper
 
-	contractAddr, err := sdk.AccAddressFromBech32(apolloFactory)
+	pendingRewards, err := getVaultRewards(app)
 	if err != nil {
-		return nil, nil
-	}
-
-	//Get all keys from store
-	prefix := util.GeneratePrefix("lm_rewards")
-	var keys [][]byte
-	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), contractAddr, prefix, func(key, value []byte) bool {
-		// walletAddr := sdk.AccAddress(key[2:22])
-		// strategyId := string(key[22:len(key)])
-		keys = append(keys, key)
-		return false
-	})
-
-	app.Logger().Info(fmt.Sprintf("Got all keys. Len: %d", len(keys)))
-
-	//SmartQuery on all keys
-	pendingRewards := make(map[string]sdk.Int)
-	total := sdk.ZeroInt()
-	for i := 0; i < len(keys); i++ {
-		key := keys[i]
-		walletAddr := sdk.AccAddress(key[2:22]).String()
-		strategyId := string(key[22:])
-
-		var stakerInfoResponse StakerInfoResponse
-		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-			ContractAddress: apolloFactory,
-			QueryMsg:        []byte(fmt.Sprintf("{\"get_staker_info\":{\"staker\":\"%s\",\"strategy_id\":%s}}", walletAddr, strategyId)),
-		}, &stakerInfoResponse); err != nil {
-			panic(fmt.Errorf("unable to query staker info: %v", err))
-		}
-
-		pendingReward := stakerInfoResponse.PendingReward
-
-		if !pendingReward.IsZero() {
-			if pendingRewards[walletAddr].IsNil() {
-				pendingRewards[walletAddr] = pendingReward
-			} else {
-				pendingRewards[walletAddr] = pendingRewards[walletAddr].Add(pendingReward)
-			}
-		}
-
-		total = total.Add(stakerInfoResponse.PendingReward)
-		app.Logger().Info(fmt.Sprintf("Fetched %d / %d. Total: %s", i, len(keys), total.String()))
+		return nil, err
 	}
 
 	i := 1
@@ -216,63 +213,367 @@ func ExportVaultRewards(app *terra.TerraApp) ([]AddressWithBalance, error) {
 		i++
 	}
 
-	app.Logger().Info(fmt.Sprintf("Finished getting vault rewards. Total: %s", total.String()))
-
 	return results, nil
 }
 
-func ExportCfeRewards(app *terra.TerraApp) (map[string]CfeAccountInfoResponse, error) {
-	app.Logger().Info("Exporting Apollo CFE Rewards 5")
+// getVaultRewards returns pending APOLLO rewards of every wallet, across strategies
+func getVaultRewards(app *terra.TerraApp) (map[string]sdk.Int, error) {
 	ctx := util.PrepCtx(app)
 	qs := util.PrepWasmQueryServer(app)
 	keeper := app.WasmKeeper
 
 	contractAddr, err := sdk.AccAddressFromBech32(apolloFactory)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
-	//Get all keys from store
-	prefix := util.GeneratePrefix("rewards")
-	addresses := make(map[string]bool)
+	//Get all entries from store
+	prefix := util.GeneratePrefix("lm_rewards")
+	var entries []lmRewardEntry
+	var iterErr error
 	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), contractAddr, prefix, func(key, value []byte) bool {
-		walletAddr := sdk.AccAddress(key[2:22]).String()
-		// strategyId := string(key[22:len(key)])
-		_, exists := addresses[walletAddr]
-		if !exists {
-			addresses[walletAddr] = true
+		parts, err := util.SplitKey(key, 2)
+		if err != nil {
+			iterErr = err
+			return true
+		}
+		walletAddr, err := util.AddressFromKey(parts[0])
+		if err != nil {
+			iterErr = err
+			return true
+		}
+		entry := lmRewardEntry{
+			WalletAddr: walletAddr,
+			StrategyId: string(parts[1]),
 		}
+		if iterErr = json.Unmarshal(value, &entry.Info); iterErr != nil {
+			return true
+		}
+		entries = append(entries, entry)
 		return false
 	})
+	if iterErr != nil {
+		return nil, fmt.Errorf("unable to decode lm_rewards: %v", iterErr)
+	}
 
-	app.Logger().Info(fmt.Sprintf("Got all keys. Len: %d", len(addresses)))
+	app.Logger().Info(fmt.Sprintf("Got all keys. Len: %d", len(entries)))
 
-	//SmartQuery on all keys
-	cfeAccounts := make(map[string]CfeAccountInfoResponse)
+	rewards, err := decodeVaultRewards(ctx, keeper, qs, entries)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode vault rewards: %v", err)
+	}
+
+	pendingRewards := make(map[string]sdk.Int)
 	total := sdk.ZeroInt()
-	i := 1
-	for walletAddr := range addresses {
-		// strategyId := string(key[22:])
+	for i, entry := range entries {
+		pendingReward := rewards[i]
+		if pendingReward.IsZero() {
+			continue
+		}
+		if pendingRewards[entry.WalletAddr].IsNil() {
+			pendingRewards[entry.WalletAddr] = pendingReward
+		} else {
+			pendingRewards[entry.WalletAddr] = pendingRewards[entry.WalletAddr].Add(pendingReward)
+		}
+		total = total.Add(pendingReward)
+	}
 
-		var rewardsResponse CfeAccountResponse
-		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-			ContractAddress: cfeVesting,
-			QueryMsg:        []byte(fmt.Sprintf("{\"cfe_account\":{\"address\":\"%s\"}}", walletAddr)),
-		}, &rewardsResponse); err != nil {
-			panic(fmt.Errorf("unable to query staker info: %v", err))
+	app.Logger().Info(fmt.Sprintf("Finished getting vault rewards. Total: %s", total.String()))
+
+	return pendingRewards, nil
+}
+
+// decodeVaultRewards computes pending rewards of lm_rewards entries from storage, following
+// the staking formula pending = pending_reward + bond_amount * (global_index - reward_index).
+// The global index of every strategy is brought up to the snapshot height with the emission
+// distributed since its last update, then a random sample of entries is checked against smart queries.
+func decodeVaultRewards(ctx context.Context, k keeper.Keeper, qs wasmtypes.QueryServer, entries []lmRewardEntry) ([]sdk.Int, error) {
+	globalIndexes, err := getGlobalIndexes(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	rewards := make([]sdk.Int, len(entries))
+	for i, entry := range entries {
+		info := entry.Info
+		if info.RewardIndex.IsNil() || info.BondAmount.IsNil() || info.PendingReward.IsNil() {
+			return nil, fmt.Errorf("incomplete lm_rewards entry of %s in strategy %s", entry.WalletAddr, entry.StrategyId)
 		}
+		globalIndex, ok := globalIndexes[entry.StrategyId]
+		if !ok {
+			return nil, fmt.Errorf("strategy %s of %s not found in the factory", entry.StrategyId, entry.WalletAddr)
+		}
+		if globalIndex.LT(info.RewardIndex) {
+			return nil, fmt.Errorf("strategy %s: global index %s below reward index %s of %s", entry.StrategyId, globalIndex, info.RewardIndex, entry.WalletAddr)
+		}
+		rewards[i] = info.PendingReward.Add(globalIndex.Sub(info.RewardIndex).MulInt(info.BondAmount).TruncateInt())
+	}
 
-		cfeAccounts[walletAddr] = rewardsResponse.Info
+	// verify a reproducible random sample against the contract
+	rng := rand.New(rand.NewSource(sdk.UnwrapSDKContext(ctx).BlockHeight()))
+	sample := rng.Perm(len(entries))
+	if len(sample) > VaultRewardsSampleSize {
+		sample = sample[:VaultRewardsSampleSize]
+	}
+	for _, i := range sample {
+		stakerInfo, err := queryStakerInfo(ctx, qs, entries[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := util.AlmostEqual(
+			fmt.Sprintf("apollo pending reward of %s in strategy %s", entries[i].WalletAddr, entries[i].StrategyId),
+			stakerInfo.PendingReward,
+			rewards[i],
+			sdk.NewInt(10),
+		); err != nil {
+			return nil, err
+		}
+	}
 
-		app.Logger().Info(fmt.Sprintf("Fetched %d / %d", i, len(addresses)))
-		i++
+	return rewards, nil
+}
+
+// getGlobalIndexes returns strategy id => global reward index at the snapshot height, read from
+// the factory strategies
+func getGlobalIndexes(ctx context.Context, k keeper.Keeper) (map[string]sdk.Dec, error) {
+	height := uint64(sdk.UnwrapSDKContext(ctx).BlockHeight())
+	globalIndexes := make(map[string]sdk.Dec)
+	var err error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(apolloFactory), util.GeneratePrefix("strategies"), func(key, value []byte) bool {
+		var strategy factoryStrategy
+		if err = json.Unmarshal(value, &strategy); err != nil {
+			err = fmt.Errorf("unable to decode strategy %s: %v", key, err)
+			return true
+		}
+		if strategy.GlobalIndex.IsNil() || strategy.TotalBondAmount.IsNil() {
+			err = fmt.Errorf("strategy %s has no reward index", key)
+			return true
+		}
+		globalIndex := strategy.GlobalIndex
+		if !strategy.TotalBondAmount.IsZero() {
+			var distributed sdk.Int
+			if distributed, err = distributedAmount(strategy.DistributionSchedule, strategy.LastDistributed, height); err != nil {
+				err = fmt.Errorf("unable to decode distribution schedule of strategy %s: %v", key, err)
+				return true
+			}
+			globalIndex = globalIndex.Add(sdk.NewDecFromInt(distributed).QuoInt(strategy.TotalBondAmount))
+		}
+		globalIndexes[string(key)] = globalIndex
+		return false
+	})
+	if err != nil {
+		return nil, err
 	}
+	return globalIndexes, nil
+}
 
+// distributedAmount is the emission of a distribution schedule between two block heights,
+// every schedule releasing its amount linearly from start to end
+func distributedAmount(schedules [][3]json.RawMessage, from uint64, to uint64) (sdk.Int, error) {
+	distributed := sdk.ZeroInt()
+	for _, schedule := range schedules {
+		var start, end uint64
+		var amount sdk.Int
+		if err := json.Unmarshal(schedule[0], &start); err != nil {
+			return sdk.Int{}, err
+		}
+		if err := json.Unmarshal(schedule[1], &end); err != nil {
+			return sdk.Int{}, err
+		}
+		if err := json.Unmarshal(schedule[2], &amount); err != nil {
+			return sdk.Int{}, err
+		}
+		if start > to || end < from || end <= start {
+			continue
+		}
+		passedFrom, passedTo := start, end
+		if from > passedFrom {
+			passedFrom = from
+		}
+		if to < passedTo {
+			passedTo = to
+		}
+		distributed = distributed.Add(amount.MulRaw(int64(passedTo - passedFrom)).QuoRaw(int64(end - start)))
+	}
+	return distributed, nil
+}
+
+func queryStakerInfo(ctx context.Context, qs wasmtypes.QueryServer, entry lmRewardEntry) (StakerInfoResponse, error) {
+	var stakerInfoResponse StakerInfoResponse
+	if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: apolloFactory,
+		QueryMsg:        []byte(fmt.Sprintf("{\"get_staker_info\":{\"staker\":\"%s\",\"strategy_id\":%s}}", entry.WalletAddr, entry.StrategyId)),
+	}, &stakerInfoResponse); err != nil {
+		return StakerInfoResponse{}, fmt.Errorf("unable to query staker info: %v", err)
+	}
+	return stakerInfoResponse, nil
+}
+
+// ExportCfeRewards returns the claimable phase 1 and phase 2 CFE rewards of every account, computed from storage.
+// Each phase vests its rewards linearly between its start and end time, minus what was claimed already:
+// claimable = total * (block time - start) / (end - start) - claimed.
+// Totals are the account's pending_reward (phase 1) and extension_pending_reward (phase 2) across strategies.
+// A random sample of accounts is checked against cfe_account queries.
+func ExportCfeRewards(app *terra.TerraApp) (map[string]CfeAccountInfoResponse, error) {
+	app.Logger().Info("Exporting Apollo CFE Rewards")
+	ctx := util.PrepCtx(app)
+	qs := util.PrepWasmQueryServer(app)
+
+	totals, err := getCfeTotals(ctx, app.WasmKeeper)
+	if err != nil {
+		return nil, err
+	}
+	app.Logger().Info(fmt.Sprintf("Got all keys. Len: %d", len(totals)))
+
+	cfeAccounts, err := decodeCfeAccounts(ctx, app.WasmKeeper, qs, totals)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode cfe accounts: %v", err)
+	}
+
+	total := sdk.ZeroInt()
+	for _, info := range cfeAccounts {
+		total = total.Add(info.Phase1Claimable).Add(info.Phase2Claimable)
+	}
 	app.Logger().Info(fmt.Sprintf("Finished getting cfe rewards. Total: %s", total.String()))
 
 	return cfeAccounts, nil
 }
 
+// getCfeTotals returns wallet => CFE rewards of both phases, summed over the factory rewards entries
+// keyed by (wallet, strategy id)
+func getCfeTotals(ctx context.Context, k keeper.Keeper) (map[string]GetTotalCfeRewardsResponse, error) {
+	contractAddr, err := sdk.AccAddressFromBech32(apolloFactory)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]GetTotalCfeRewardsResponse)
+	var iterErr error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), contractAddr, util.GeneratePrefix("rewards"), func(key, value []byte) bool {
+		var parts [][]byte
+		if parts, iterErr = util.SplitKey(key, 2); iterErr != nil {
+			return true
+		}
+		var walletAddr string
+		if walletAddr, iterErr = util.AddressFromKey(parts[0]); iterErr != nil {
+			return true
+		}
+		var reward GetTotalCfeRewardsResponse
+		if iterErr = json.Unmarshal(value, &reward); iterErr != nil {
+			return true
+		}
+
+		t, ok := totals[walletAddr]
+		if !ok {
+			t = GetTotalCfeRewardsResponse{PendingReward: sdk.ZeroInt(), ExtensionPendingReward: sdk.ZeroInt()}
+		}
+		t.PendingReward = t.PendingReward.Add(orZero(reward.PendingReward))
+		t.ExtensionPendingReward = t.ExtensionPendingReward.Add(orZero(reward.ExtensionPendingReward))
+		totals[walletAddr] = t
+		return false
+	})
+	if iterErr != nil {
+		return nil, fmt.Errorf("unable to decode factory rewards: %v", iterErr)
+	}
+	return totals, nil
+}
+
+// decodeCfeAccounts computes the claimable CFE rewards of every wallet with totals, from the vesting
+// schedule and the claimed amounts of the vesting contract's cfe_accounts, then verifies a random sample
+func decodeCfeAccounts(ctx context.Context, k keeper.Keeper, qs wasmtypes.QueryServer, totals map[string]GetTotalCfeRewardsResponse) (map[string]CfeAccountInfoResponse, error) {
+	res, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+		ContractAddress: cfeVesting,
+		Key:             []byte("config"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var config cfeConfig
+	if err := json.Unmarshal(res.Data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse cfe config: %v", err)
+	}
+
+	// wallets that never claimed have no account yet
+	claimed := make(map[string]CfeAccountInfoResponse)
+	var iterErr error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(cfeVesting), util.GeneratePrefix("cfe_accounts"), func(key, value []byte) bool {
+		var walletAddr string
+		if walletAddr, iterErr = util.AddressFromKey(key); iterErr != nil {
+			return true
+		}
+		var info CfeAccountInfoResponse
+		if iterErr = json.Unmarshal(value, &info); iterErr != nil {
+			return true
+		}
+		claimed[walletAddr] = info
+		return false
+	})
+	if iterErr != nil {
+		return nil, fmt.Errorf("unable to decode cfe_accounts: %v", iterErr)
+	}
+
+	now := uint64(sdk.UnwrapSDKContext(ctx).BlockTime().Unix())
+	var addresses []string
+	cfeAccounts := make(map[string]CfeAccountInfoResponse)
+	for walletAddr, t := range totals {
+		info := claimed[walletAddr]
+		info.PendingRewardCLaimed = orZero(info.PendingRewardCLaimed)
+		info.ExtensionPendingRewardClaimed = orZero(info.ExtensionPendingRewardClaimed)
+		info.Phase1Claimable = vestedAmount(t.PendingReward, config.Phase1Start, config.Phase1End, now).Sub(info.PendingRewardCLaimed)
+		info.Phase2Claimable = vestedAmount(t.ExtensionPendingReward, config.Phase2Start, config.Phase2End, now).Sub(info.ExtensionPendingRewardClaimed)
+		if info.Phase1Claimable.IsNegative() || info.Phase2Claimable.IsNegative() {
+			return nil, fmt.Errorf("cfe account of %s claimed more than vested", walletAddr)
+		}
+		cfeAccounts[walletAddr] = info
+		addresses = append(addresses, walletAddr)
+	}
+
+	// verify a reproducible random sample against the contract
+	sort.Strings(addresses)
+	rng := rand.New(rand.NewSource(sdk.UnwrapSDKContext(ctx).BlockHeight()))
+	sample := rng.Perm(len(addresses))
+	if len(sample) > VaultRewardsSampleSize {
+		sample = sample[:VaultRewardsSampleSize]
+	}
+	for _, i := range sample {
+		walletAddr := addresses[i]
+		info, err := queryCfeAccount(ctx, qs, walletAddr)
+		if err != nil {
+			return nil, err
+		}
+		if err := util.AlmostEqual(fmt.Sprintf("apollo cfe phase 1 claimable of %s", walletAddr), info.Phase1Claimable, cfeAccounts[walletAddr].Phase1Claimable, sdk.NewInt(10)); err != nil {
+			return nil, err
+		}
+		if err := util.AlmostEqual(fmt.Sprintf("apollo cfe phase 2 claimable of %s", walletAddr), info.Phase2Claimable, cfeAccounts[walletAddr].Phase2Claimable, sdk.NewInt(10)); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfeAccounts, nil
+}
+
+// vestedAmount is the part of total released linearly from start to end at now
+func vestedAmount(total sdk.Int, start uint64, end uint64, now uint64) sdk.Int {
+	if now <= start {
+		return sdk.ZeroInt()
+	}
+	if now >= end || end <= start {
+		return total
+	}
+	return total.Mul(sdk.NewIntFromUint64(now - start)).Quo(sdk.NewIntFromUint64(end - start))
+}
+
+func queryCfeAccount(ctx context.Context, qs wasmtypes.QueryServer, walletAddr string) (CfeAccountInfoResponse, error) {
+	var rewardsResponse CfeAccountResponse
+	if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: cfeVesting,
+		QueryMsg:        []byte(fmt.Sprintf("{\"cfe_account\":{\"address\":\"%s\"}}", walletAddr)),
+	}, &rewardsResponse); err != nil {
+		return CfeAccountInfoResponse{}, fmt.Errorf("unable to query cfe account: %v", err)
+	}
+	return rewardsResponse.Info, nil
+}
+
 func ExportAstroGeneratorHoldings(app *terra.TerraApp) ([]AddressWithBalance, error) {
 	app.Logger().Info("Exporting Astroport Generator Holdings")
 	ctx := util.PrepCtx(app)
@@ -349,110 +650,44 @@ func ExportAstroGeneratorHoldings(app *terra.TerraApp) ([]AddressWithBalance, er
 func ExportAstroLockdropHoldings(app *terra.TerraApp) ([]AddressWithBalance, error) {
 	app.Logger().Info("Exporting Astroport Lockdrop Holdings, fixed")
 	ctx := util.PrepCtx(app)
-	qs := util.PrepWasmQueryServer(app)
 	keeper := app.WasmKeeper
 
-	astroPairAddr := "terra1zpnhtf9h5s7ze2ewlqyer83sr4043qcq64zfc4"
 	terraswapLpToken := "terra1n3gt4k3vth0uppk0urche6m3geu9eqcyujt88q"
 
-	var pool pool
-	//Query pool
-	if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-		ContractAddress: astroPairAddr,
-		QueryMsg:        []byte("{\"pool\":{}}"),
-	}, &pool); err != nil {
-		panic(fmt.Errorf("unable to... %v", err))
-	}
-
-	//Get Apollo tokens in pool and total LP tokens
-	asset0 := pickDenomOrContractAddress(pool.Assets[0].AssetInfo)
-	asset1 := pickDenomOrContractAddress(pool.Assets[1].AssetInfo)
-	var tokensInPair sdk.Int
-	if asset0 == apolloToken {
-		tokensInPair = pool.Assets[0].Amount
-	} else if asset1 == apolloToken {
-		tokensInPair = pool.Assets[1].Amount
-	} else {
-		panic("Apollo token not found in pair")
+	positions, err := astroport.ExportLockdropPositions(app)
+	if err != nil {
+		return nil, err
 	}
-	totalShares := pool.TotalShare
-
-	//Get lockdrop deposits into generator
-	type poolInfo struct {
-		TerraswapAmountInLockup sdk.Int `json:"terraswap_amount_in_lockups"`
-		MigrationInfo           struct {
-			AstroportLPToken string `json:"astroport_lp_token"`
-		} `json:"migration_info"`
-	}
-	// res, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
-	// 	ContractAddress: astroportLockdrop,
-	// 	Key:             util.GeneratePrefix(fmt.Sprintf("LiquidityPools%s", terraswapLpToken)),
-	// })
-	// if err != nil {
-	// 	panic(fmt.Errorf("unable to... %v", err))
-	// }
-	// var pi poolInfo
-	// util.MustUnmarshalTMJSON(res.Data, &pi)
-
-	var pi poolInfo
-	poolsPrefix := util.GeneratePrefix("LiquidityPools")
-	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(astroportLockdrop), poolsPrefix, func(key, value []byte) bool {
-		var lpAddr = string(key)
-		if lpAddr == terraswapLpToken {
-			util.MustUnmarshalTMJSON(value, &pi)
-			return false
-		} else {
-			return false
-		}
-	})
-
-	var lpLockedInGenerator sdk.Int
-	util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-		ContractAddress: astroportGenerator,
-		QueryMsg:        []byte(fmt.Sprintf("{ \"deposit\": { \"lp_token\": \"%s\", \"user\": \"%s\" } }", pi.MigrationInfo.AstroportLPToken, astroportLockdrop)),
-	}, &lpLockedInGenerator)
 
 	//Get all lp tokens from lockdrop and convert to Apollo
-	prefix := util.GeneratePrefix("lockup_position", terraswapLpToken)
-	var lockupInfo struct {
-		LPUnitsLocked          sdk.Int `json:"lp_units_locked"`
-		AstroportLPTransferred sdk.Int `json:"astroport_lp_transferred"`
-	}
-
 	var results []AddressWithBalance
 	total := sdk.ZeroInt()
-	i := 1
-
-	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(astroportLockdrop), prefix, func(key, value []byte) bool {
-		userAddress := string(key[2:46])
-
-		util.MustUnmarshalTMJSON(value, &lockupInfo)
 
-		// If LP transferred is not nil, means the user has withdrawn all LPs after unlock
-		if !lockupInfo.LPUnitsLocked.IsNil() && lockupInfo.AstroportLPTransferred.IsNil() {
-			terraswapLpLocked := lockupInfo.LPUnitsLocked
-			astroLpLocked := terraswapLpLocked.Mul(lpLockedInGenerator).Quo(pi.TerraswapAmountInLockup)
-
-			shareRatio := sdk.NewDecFromInt(astroLpLocked).Quo(sdk.NewDecFromInt(totalShares))
-			usersApolloTokens := shareRatio.MulInt(tokensInPair).TruncateInt()
-
-			_, err := keeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), util.ToAddress(userAddress))
-			isContract := err == nil
+	for _, position := range positions {
+		if position.TerraswapLpToken != terraswapLpToken {
+			continue
+		}
 
-			results = append(results, AddressWithBalance{
-				Address:    userAddress,
-				Balance:    usersApolloTokens.String(),
-				IsContract: isContract,
-			})
-			total = total.Add(usersApolloTokens)
+		// the position's astroport LP, already converted to the pair's assets
+		usersApolloTokens := sdk.ZeroInt()
+		for _, asset := range position.Underlying {
+			if dex.PickDenomOrContractAddress(asset.AssetInfo) == apolloToken {
+				usersApolloTokens = asset.Amount
+			}
 		}
 
-		app.Logger().Info(fmt.Sprintf("Fetched %d / ?. Total Apollo tokens: %s", i, total.String()))
-		i++
+		_, err := keeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), util.ToAddress(position.User))
+		isContract := err == nil
 
-		return false
-	})
+		results = append(results, AddressWithBalance{
+			Address:    position.User,
+			Balance:    usersApolloTokens.String(),
+			IsContract: isContract,
+		})
+		total = total.Add(usersApolloTokens)
+	}
 
+	app.Logger().Info(fmt.Sprintf("... %d lockdrop positions, total Apollo tokens: %s", len(results), total))
 	return results, nil
 }
 
@@ -589,7 +824,7 @@ func ExportApolloAstroVaultHoldings(app *terra.TerraApp) ([]AddressWithBalance,
 	total := sdk.ZeroInt()
 	i := 1
 
-	strat := util.ToAddress("terra1x7v7qvumfl36g5jh0mtqx3c4g8c35sn0sqfuqp")
+	strat := util.ToAddress(astroStaticStrategy)
 
 	lpHoldings, _, err := getLpHoldingsForStrat(ctx, app.WasmKeeper, strat)
 	if err != nil {
@@ -651,7 +886,7 @@ func ExportApolloTerraswapVaultHoldings(app *terra.TerraApp) ([]AddressWithBalan
 	total := sdk.ZeroInt()
 	i := 1
 
-	strat := util.ToAddress("terra14ge98vxgp3ey90d38wwk9xu73wydjz8vd66h3f")
+	strat := util.ToAddress(terraswapStaticStrategy)
 
 	lpHoldings, _, err := getLpHoldingsForStrat(ctx, app.WasmKeeper, strat)
 	if err != nil {
@@ -683,11 +918,11 @@ func ExportStaticVaultLPs(app *terra.TerraApp) (map[string]map[string]map[string
 	app.Logger().Info("Exporting Apollo Vaults")
 	ctx := util.PrepCtx(app)
 
-	astroStaticStrat, err := sdk.AccAddressFromBech32("terra1x7v7qvumfl36g5jh0mtqx3c4g8c35sn0sqfuqp")
+	astroStaticStrat, err := sdk.AccAddressFromBech32(astroStaticStrategy)
 	if err != nil {
 		log.Println(err)
 	}
-	terraswapStaticStrat, err := sdk.AccAddressFromBech32("terra14ge98vxgp3ey90d38wwk9xu73wydjz8vd66h3f")
+	terraswapStaticStrat, err := sdk.AccAddressFromBech32(terraswapStaticStrategy)
 	if err != nil {
 		log.Println(err)
 	}
@@ -823,3 +1058,10 @@ func getListOfUsers(app *terra.TerraApp, ctx context.Context, keeper keeper.Keep
 	})
 	return users, nil
 }
+
+func orZero(i sdk.Int) sdk.Int {
+	if i.IsNil() {
+		return sdk.ZeroInt()
+	}
+	return i
+}
@@ -0,0 +1,196 @@
+package apollo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/dex"
+	util "github.com/terra-money/core/app/export/util"
+	"github.com/terra-money/core/x/wasm/keeper"
+	wasmtypes "github.com/terra-money/core/x/wasm/types"
+)
+
+// StrategyPosition is a wallet's deposit in an Apollo strategy
+type StrategyPosition struct {
+	Strategy   string      `json:"strategy"`
+	Shares     sdk.Int     `json:"shares"`
+	LpToken    string      `json:"lp_token"`
+	LpAmount   sdk.Int     `json:"lp_amount"`
+	Underlying []dex.Asset `json:"underlying"`
+}
+
+// UserPosition is everything a wallet holds in Apollo and in external APOLLO farms.
+// External holdings are expressed in APOLLO tokens.
+type UserPosition struct {
+	Address    string             `json:"address"`
+	IsContract bool               `json:"is_contract"`
+	Strategies []StrategyPosition `json:"strategies"`
+
+	PendingRewards     sdk.Int `json:"pending_rewards"`
+	CfePhase1Claimable sdk.Int `json:"cfe_phase1_claimable"`
+	CfePhase2Claimable sdk.Int `json:"cfe_phase2_claimable"`
+
+	AstroGenerator sdk.Int `json:"astro_generator"`
+	AstroLockdrop  sdk.Int `json:"astro_lockdrop"`
+	SpectrumVault  sdk.Int `json:"spectrum_vault"`
+}
+
+// ExportUserPositions returns the positions of every Apollo user, keyed by wallet
+func ExportUserPositions(app *terra.TerraApp) (map[string]*UserPosition, error) {
+	app.Logger().Info("Exporting Apollo user positions")
+	ctx := util.PrepCtx(app)
+	qs := util.PrepWasmQueryServer(app)
+	k := app.WasmKeeper
+
+	positions := make(map[string]*UserPosition)
+	get := func(addr string) *UserPosition {
+		p, ok := positions[addr]
+		if !ok {
+			_, err := k.GetContractInfo(sdk.UnwrapSDKContext(ctx), util.ToAddress(addr))
+			p = &UserPosition{
+				Address:            addr,
+				IsContract:         err == nil,
+				PendingRewards:     sdk.ZeroInt(),
+				CfePhase1Claimable: sdk.ZeroInt(),
+				CfePhase2Claimable: sdk.ZeroInt(),
+				AstroGenerator:     sdk.ZeroInt(),
+				AstroLockdrop:      sdk.ZeroInt(),
+				SpectrumVault:      sdk.ZeroInt(),
+			}
+			positions[addr] = p
+		}
+		return p
+	}
+
+	// 1. strategies
+	strats, err := getListOfStrategies(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	for _, static := range []string{astroStaticStrategy, terraswapStaticStrategy} {
+		found := false
+		for _, strat := range strats {
+			found = found || strat.String() == static
+		}
+		if !found {
+			strats = append(strats, util.ToAddress(static))
+		}
+	}
+	for _, strat := range strats {
+		stratPositions, err := getStrategyPositions(ctx, k, qs, strat)
+		if err != nil {
+			return nil, err
+		}
+		for addr, sp := range stratPositions {
+			p := get(addr)
+			p.Strategies = append(p.Strategies, sp)
+		}
+	}
+
+	// 2. rewards
+	rewards, err := getVaultRewards(app)
+	if err != nil {
+		return nil, err
+	}
+	for addr, reward := range rewards {
+		p := get(addr)
+		p.PendingRewards = p.PendingRewards.Add(reward)
+	}
+	cfeAccounts, err := ExportCfeRewards(app)
+	if err != nil {
+		return nil, err
+	}
+	for addr, account := range cfeAccounts {
+		p := get(addr)
+		if !account.Phase1Claimable.IsNil() {
+			p.CfePhase1Claimable = account.Phase1Claimable
+		}
+		if !account.Phase2Claimable.IsNil() {
+			p.CfePhase2Claimable = account.Phase2Claimable
+		}
+	}
+
+	// 3. external APOLLO holdings
+	for _, external := range []struct {
+		export func(*terra.TerraApp) ([]AddressWithBalance, error)
+		field  func(*UserPosition) *sdk.Int
+	}{
+		{ExportAstroGeneratorHoldings, func(p *UserPosition) *sdk.Int { return &p.AstroGenerator }},
+		{ExportAstroLockdropHoldings, func(p *UserPosition) *sdk.Int { return &p.AstroLockdrop }},
+		{ExportSpecVaultHoldings, func(p *UserPosition) *sdk.Int { return &p.SpectrumVault }},
+	} {
+		holdings, err := external.export(app)
+		if err != nil {
+			return nil, err
+		}
+		for _, holding := range holdings {
+			amount, ok := sdk.NewIntFromString(holding.Balance)
+			if !ok {
+				return nil, fmt.Errorf("invalid balance %s of %s", holding.Balance, holding.Address)
+			}
+			field := external.field(get(holding.Address))
+			*field = field.Add(amount)
+		}
+	}
+
+	for _, p := range positions {
+		sort.Slice(p.Strategies, func(i, j int) bool {
+			return p.Strategies[i].Strategy < p.Strategies[j].Strategy
+		})
+	}
+
+	app.Logger().Info(fmt.Sprintf("... %d apollo users", len(positions)))
+	return positions, nil
+}
+
+// getStrategyPositions returns shares, LP and underlying assets of every depositor of a strategy
+func getStrategyPositions(ctx context.Context, k keeper.Keeper, qs wasmtypes.QueryServer, strategyAddr sdk.AccAddress) (map[string]StrategyPosition, error) {
+	lpTokenAddr, tokenPair, err := getStrategyConfig(ctx, k, strategyAddr)
+	if err != nil {
+		return nil, err
+	}
+	stratInfo, err := getStrategyInfo(ctx, k, strategyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool dex.Pool
+	if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: tokenPair.String(),
+		QueryMsg:        []byte("{\"pool\":{}}"),
+	}, &pool); err != nil {
+		return nil, fmt.Errorf("unable to query pool of %s: %v", tokenPair, err)
+	}
+
+	positions := make(map[string]StrategyPosition)
+	var iterErr error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), strategyAddr, util.GeneratePrefix("user"), func(key, value []byte) bool {
+		var userInfo UserInfo
+		if iterErr = json.Unmarshal(value, &userInfo); iterErr != nil {
+			return true
+		}
+		if userInfo.Shares.IsNil() || userInfo.Shares.IsZero() {
+			return false
+		}
+		lpAmount := userInfo.Shares.Mul(stratInfo.TotalBondAmount).Quo(stratInfo.TotalShares)
+
+		var underlying []dex.Asset
+		for i, amount := range dex.GetShareInAssets(pool, lpAmount, pool.TotalShare) {
+			underlying = append(underlying, dex.Asset{AssetInfo: pool.Assets[i].AssetInfo, Amount: amount})
+		}
+
+		positions[sdk.AccAddress(key).String()] = StrategyPosition{
+			Strategy:   strategyAddr.String(),
+			Shares:     userInfo.Shares,
+			LpToken:    lpTokenAddr.String(),
+			LpAmount:   lpAmount,
+			Underlying: underlying,
+		}
+		return false
+	})
+	return positions, iterErr
+}
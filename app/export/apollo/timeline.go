@@ -0,0 +1,126 @@
+package apollo
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	util "github.com/terra-money/core/app/export/util"
+)
+
+// RewardSnapshot holds the Apollo rewards of every user at one height
+type RewardSnapshot struct {
+	Height  int64
+	Rewards map[string]sdk.Int
+	Cfe     map[string]CfeAccountInfoResponse
+}
+
+// RewardPoint is a user's rewards at one height, with the change since the previous height
+type RewardPoint struct {
+	Height                  int64   `json:"height"`
+	PendingRewards          sdk.Int `json:"pending_rewards"`
+	CfePhase1Claimable      sdk.Int `json:"cfe_phase1_claimable"`
+	CfePhase2Claimable      sdk.Int `json:"cfe_phase2_claimable"`
+	PendingRewardsDelta     sdk.Int `json:"pending_rewards_delta"`
+	CfePhase1ClaimableDelta sdk.Int `json:"cfe_phase1_claimable_delta"`
+	CfePhase2ClaimableDelta sdk.Int `json:"cfe_phase2_claimable_delta"`
+}
+
+// ExportRewardSnapshot runs the vault and CFE reward exports at the app's current height
+func ExportRewardSnapshot(app *terra.TerraApp) (RewardSnapshot, error) {
+	rewards, err := getVaultRewards(app)
+	if err != nil {
+		return RewardSnapshot{}, err
+	}
+	cfe, err := ExportCfeRewards(app)
+	if err != nil {
+		return RewardSnapshot{}, err
+	}
+	return RewardSnapshot{
+		Height:  app.LastBlockHeight(),
+		Rewards: rewards,
+		Cfe:     cfe,
+	}, nil
+}
+
+// BuildRewardTimeline turns snapshots into a time series per user.
+// Users missing at a height have zero rewards there; the first point has zero deltas.
+func BuildRewardTimeline(snapshots []RewardSnapshot) map[string][]RewardPoint {
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Height < snapshots[j].Height
+	})
+
+	users := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		for addr := range snapshot.Rewards {
+			users[addr] = true
+		}
+		for addr := range snapshot.Cfe {
+			users[addr] = true
+		}
+	}
+
+	timeline := make(map[string][]RewardPoint)
+	for addr := range users {
+		var points []RewardPoint
+		for i, snapshot := range snapshots {
+			point := RewardPoint{
+				Height:             snapshot.Height,
+				PendingRewards:     orZero(snapshot.Rewards[addr]),
+				CfePhase1Claimable: orZero(snapshot.Cfe[addr].Phase1Claimable),
+				CfePhase2Claimable: orZero(snapshot.Cfe[addr].Phase2Claimable),
+			}
+			if i == 0 {
+				point.PendingRewardsDelta = sdk.ZeroInt()
+				point.CfePhase1ClaimableDelta = sdk.ZeroInt()
+				point.CfePhase2ClaimableDelta = sdk.ZeroInt()
+			} else {
+				prev := points[i-1]
+				point.PendingRewardsDelta = point.PendingRewards.Sub(prev.PendingRewards)
+				point.CfePhase1ClaimableDelta = point.CfePhase1Claimable.Sub(prev.CfePhase1Claimable)
+				point.CfePhase2ClaimableDelta = point.CfePhase2Claimable.Sub(prev.CfePhase2Claimable)
+			}
+			points = append(points, point)
+		}
+		timeline[addr] = points
+	}
+	return timeline
+}
+
+// WriteRewardTimeline saves the timeline to <dir>/apollo-reward-timeline.json and .csv
+func WriteRewardTimeline(dir string, timeline map[string][]RewardPoint) error {
+	if err := util.SaveDataToFile(filepath.Join(dir, "apollo-reward-timeline.json"), timeline); err != nil {
+		return err
+	}
+
+	var users []string
+	for addr := range timeline {
+		users = append(users, addr)
+	}
+	sort.Strings(users)
+
+	var data [][]string
+	for _, addr := range users {
+		for _, point := range timeline[addr] {
+			data = append(data, []string{
+				addr,
+				strconv.FormatInt(point.Height, 10),
+				point.PendingRewards.String(),
+				point.PendingRewardsDelta.String(),
+				point.CfePhase1Claimable.String(),
+				point.CfePhase1ClaimableDelta.String(),
+				point.CfePhase2Claimable.String(),
+				point.CfePhase2ClaimableDelta.String(),
+			})
+		}
+	}
+	util.ToCsv(filepath.Join(dir, "apollo-reward-timeline.csv"), []string{
+		"address", "height",
+		"pending_rewards", "pending_rewards_delta",
+		"cfe_phase1_claimable", "cfe_phase1_claimable_delta",
+		"cfe_phase2_claimable", "cfe_phase2_claimable_delta",
+	}, data)
+	return nil
+}
@@ -0,0 +1,210 @@
+package astroport
+
+import (
+	"encoding/json"
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+	wasmtypes "github.com/terra-money/core/x/wasm/types"
+)
+
+type (
+	generatorConfig struct {
+		AstroToken      string  `json:"astro_token"`
+		TokensPerBlock  sdk.Int `json:"tokens_per_block"`
+		TotalAllocPoint sdk.Int `json:"total_alloc_point"`
+	}
+
+	generatorPoolInfo struct {
+		AllocPoint                      sdk.Int `json:"alloc_point"`
+		LastRewardBlock                 uint64  `json:"last_reward_block"`
+		AccumulatedRewardsPerShare      sdk.Dec `json:"accumulated_rewards_per_share"`
+		RewardProxy                     *string `json:"reward_proxy,omitempty"`
+		AccumulatedProxyRewardsPerShare sdk.Dec `json:"accumulated_proxy_rewards_per_share"`
+		ProxyRewardBalanceBeforeUpdate  sdk.Int `json:"proxy_reward_balance_before_update"`
+		OrphanProxyRewards              sdk.Int `json:"orphan_proxy_rewards"`
+	}
+
+	generatorUserInfo struct {
+		Amount          sdk.Int `json:"amount"`
+		RewardDebt      sdk.Int `json:"reward_debt"`
+		RewardDebtProxy sdk.Int `json:"reward_debt_proxy"`
+	}
+
+	generatorPool struct {
+		info             generatorPoolInfo
+		accPerShare      sdk.Dec
+		proxyAccPerShare sdk.Dec
+		proxyToken       string
+	}
+)
+
+// ExportGeneratorRewards exports pending ASTRO and proxy rewards (ANC, MIR, PSI, ...) of every generator depositor.
+// Rewards are computed from storage following the generator's pending_token formula:
+// pending = amount * (acc_per_share + blocks since last update * astro per block / lp supply) - reward_debt.
+// Proxy rewards add what the proxy received and still has pending since the generator's last update,
+// as the generator's pending_token query does.
+// Denoms of the result are the reward token addresses, it is not meant to be merged into the LUNA/UST snapshot.
+func ExportGeneratorRewards(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+	app.Logger().Info("Exporting Astroport generator pending rewards")
+	ctx := util.PrepCtx(app)
+	qs := util.PrepWasmQueryServer(app)
+	keeper := app.WasmKeeper
+	generator := util.ToAddress(AddressAstroportGenerator)
+	height := uint64(app.LastBlockHeight())
+
+	res, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+		ContractAddress: AddressAstroportGenerator,
+		Key:             []byte("config"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var config generatorConfig
+	if err := json.Unmarshal(res.Data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse generator config: %v", err)
+	}
+
+	// 1. load every pool and bring its ASTRO index up to the snapshot height
+	pools := make(map[string]*generatorPool)
+	var iterErr error
+	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), generator, util.GeneratePrefix("pool_info"), func(key, value []byte) bool {
+		lpAddr := string(key)
+		var info generatorPoolInfo
+		if iterErr = json.Unmarshal(value, &info); iterErr != nil {
+			return true
+		}
+		pools[lpAddr] = &generatorPool{
+			info:        info,
+			accPerShare: info.AccumulatedRewardsPerShare,
+		}
+		return false
+	})
+	if iterErr != nil {
+		return nil, iterErr
+	}
+
+	for lpAddr, pool := range pools {
+		var lpSupply sdk.Int
+		if pool.info.RewardProxy != nil {
+			// LP tokens are staked by the proxy
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: *pool.info.RewardProxy,
+				QueryMsg:        []byte("{\"deposit\":{}}"),
+			}, &lpSupply); err != nil {
+				return nil, fmt.Errorf("unable to query proxy deposit %s: %v", *pool.info.RewardProxy, err)
+			}
+
+			var proxyConfig struct {
+				RewardTokenAddr string `json:"reward_token_addr"`
+			}
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: *pool.info.RewardProxy,
+				QueryMsg:        []byte("{\"config\":{}}"),
+			}, &proxyConfig); err != nil {
+				return nil, fmt.Errorf("unable to query proxy config %s: %v", *pool.info.RewardProxy, err)
+			}
+			pool.proxyToken = proxyConfig.RewardTokenAddr
+
+			// rewards held and pending on the proxy that the generator has not indexed yet
+			pool.proxyAccPerShare = pool.info.AccumulatedProxyRewardsPerShare
+			if pool.proxyAccPerShare.IsNil() {
+				pool.proxyAccPerShare = sdk.ZeroDec()
+			}
+			var rewardBalance, pendingReward sdk.Int
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: *pool.info.RewardProxy,
+				QueryMsg:        []byte("{\"reward\":{}}"),
+			}, &rewardBalance); err != nil {
+				return nil, fmt.Errorf("unable to query proxy reward %s: %v", *pool.info.RewardProxy, err)
+			}
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: *pool.info.RewardProxy,
+				QueryMsg:        []byte("{\"pending_token\":{}}"),
+			}, &pendingReward); err != nil {
+				return nil, fmt.Errorf("unable to query proxy pending token %s: %v", *pool.info.RewardProxy, err)
+			}
+			tokenRewards := rewardBalance.Add(pendingReward)
+			if !pool.info.ProxyRewardBalanceBeforeUpdate.IsNil() {
+				tokenRewards = tokenRewards.Sub(pool.info.ProxyRewardBalanceBeforeUpdate)
+			}
+			if tokenRewards.IsPositive() && !lpSupply.IsZero() {
+				pool.proxyAccPerShare = pool.proxyAccPerShare.Add(sdk.NewDecFromInt(tokenRewards).QuoInt(lpSupply))
+			}
+		} else {
+			lpSupply, err = util.GetCW20Balance(ctx, qs, lpAddr, AddressAstroportGenerator)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if height > pool.info.LastRewardBlock && !lpSupply.IsZero() && !config.TotalAllocPoint.IsZero() {
+			blocks := sdk.NewIntFromUint64(height - pool.info.LastRewardBlock)
+			tokenRewards := blocks.Mul(config.TokensPerBlock).Mul(pool.info.AllocPoint).Quo(config.TotalAllocPoint)
+			pool.accPerShare = pool.accPerShare.Add(sdk.NewDecFromInt(tokenRewards).QuoInt(lpSupply))
+		}
+	}
+
+	// 2. compute pending rewards of every depositor
+	snapshot := make(util.SnapshotBalanceAggregateMap)
+	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), generator, util.GeneratePrefix("user_info"), func(key, value []byte) bool {
+		// keyed by (lp token, user)
+		var parts [][]byte
+		if parts, iterErr = util.SplitKey(key, 2); iterErr != nil {
+			return true
+		}
+		var lpAddr, userAddr string
+		if lpAddr, iterErr = util.AddressFromKey(parts[0]); iterErr != nil {
+			return true
+		}
+		if userAddr, iterErr = util.AddressFromKey(parts[1]); iterErr != nil {
+			return true
+		}
+
+		pool, ok := pools[lpAddr]
+		if !ok {
+			return false
+		}
+
+		var userInfo generatorUserInfo
+		if iterErr = json.Unmarshal(value, &userInfo); iterErr != nil {
+			return true
+		}
+		if userInfo.Amount.IsNil() || userInfo.Amount.IsZero() {
+			return false
+		}
+
+		pendingAstro := pool.accPerShare.MulInt(userInfo.Amount).TruncateInt()
+		if !userInfo.RewardDebt.IsNil() {
+			pendingAstro = pendingAstro.Sub(userInfo.RewardDebt)
+		}
+		if pendingAstro.IsPositive() {
+			snapshot.AppendOrAddBalance(userAddr, util.SnapshotBalance{
+				Denom:   config.AstroToken,
+				Balance: pendingAstro,
+			})
+		}
+
+		if pool.proxyToken != "" {
+			pendingProxy := pool.proxyAccPerShare.MulInt(userInfo.Amount).TruncateInt()
+			if !userInfo.RewardDebtProxy.IsNil() {
+				pendingProxy = pendingProxy.Sub(userInfo.RewardDebtProxy)
+			}
+			if pendingProxy.IsPositive() {
+				snapshot.AppendOrAddBalance(userAddr, util.SnapshotBalance{
+					Denom:   pool.proxyToken,
+					Balance: pendingProxy,
+				})
+			}
+		}
+		return false
+	})
+	if iterErr != nil {
+		return nil, fmt.Errorf("unable to decode generator user_info: %v", iterErr)
+	}
+
+	app.Logger().Info(fmt.Sprintf("... pending ASTRO: %s", snapshot.SumOfDenom(config.AstroToken)))
+	return snapshot, nil
+}
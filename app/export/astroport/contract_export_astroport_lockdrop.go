@@ -1,10 +1,16 @@
 package astroport
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	"github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/dex"
 	"github.com/terra-money/core/app/export/util"
 	wasmtypes "github.com/terra-money/core/x/wasm/types"
 )
@@ -22,99 +28,333 @@ import (
 // VKR/UST - terra17fysmcl52xjrs8ldswhz7n6mt37r9cmpcguack
 // PSI/UST - terra1q6r8hfdl203htfvpsmyh8x689lp2g0m7856fwd
 // APOLLO/UST - terra1n3gt4k3vth0uppk0urche6m3geu9eqcyujt88q
-// means we only need to care about UST/LUNA/bLUNA
+// the genesis pipeline only cares about UST/LUNA/bLUNA, other pools are exported for reward tooling.
 
-func ExportAstroportLockdrop(app *app.TerraApp, snapshot util.SnapshotBalanceAggregateMap) (map[string]map[string]map[string]sdk.Int, error) {
+// LockdropPosition is a single lockup of a user (one per pool and duration)
+type LockdropPosition struct {
+	User             string `json:"user"`
+	TerraswapLpToken string `json:"terraswap_lp_token"`
+	AstroportLpToken string `json:"astroport_lp_token"`
+	AstroportPair    string `json:"astroport_pair"`
+
+	// terraswap LP units originally locked
+	LpUnitsLocked sdk.Int `json:"lp_units_locked"`
+	// astroport LP owned through the lockdrop's generator deposit
+	AstroportLp sdk.Int `json:"astroport_lp"`
+	// lock duration in weeks
+	Duration        uint64 `json:"duration"`
+	UnlockTimestamp uint64 `json:"unlock_timestamp"`
+
+	// lockdrop incentives of the position
+	AstroClaimed   sdk.Int `json:"astro_claimed"`
+	AstroUnclaimed sdk.Int `json:"astro_unclaimed"`
+	// generator ASTRO accrued on the position's share of the lockdrop deposit
+	GeneratorAstro sdk.Int `json:"generator_astro"`
+
+	// AstroportLp converted to the pair's assets
+	Underlying []dex.Asset `json:"underlying"`
+}
+
+type (
+	lockupInfo struct {
+		LPUnitsLocked          sdk.Int `json:"lp_units_locked"`
+		AstroportLPTransferred sdk.Int `json:"astroport_lp_transferred"`
+		AstroRewards           sdk.Int `json:"astro_rewards"`
+		GeneratorAstroDebt     sdk.Int `json:"generator_astro_debt"`
+		UnlockTimestamp        uint64  `json:"unlock_timestamp"`
+	}
+
+	lockdropConfig struct {
+		WeeklyMultiplier   uint64  `json:"weekly_multiplier"`
+		WeeklyDivider      uint64  `json:"weekly_divider"`
+		LockdropIncentives sdk.Int `json:"lockdrop_incentives"`
+	}
 
+	lockdropState struct {
+		TotalIncentivesShare uint64 `json:"total_incentives_share"`
+	}
+
+	lockdropUserInfo struct {
+		LockdropClaimed bool `json:"lockdrop_claimed"`
+	}
+)
+
+// ExportLockdropPositions exports every open position of all migrated lockdrop pools.
+func ExportLockdropPositions(app *app.TerraApp) ([]LockdropPosition, error) {
+	app.Logger().Info("Exporting Astroport lockdrop positions")
 	ctx := util.PrepCtx(app)
 	qs := util.PrepWasmQueryServer(app)
 	keeper := app.WasmKeeper
+	lockdrop := util.ToAddress(AddressAstroportLockdrop)
 
-	// 1. iterate all pairs & get lp token total share. key is in astroport pair
-	var pm = make(poolMap)
+	var config lockdropConfig
+	if err := rawItem(ctx, qs, AddressAstroportLockdrop, "config", &config); err != nil {
+		return nil, err
+	}
+	var state lockdropState
+	if err := rawItem(ctx, qs, AddressAstroportLockdrop, "state", &state); err != nil {
+		return nil, err
+	}
 
-	pairPrefix := util.GeneratePrefix("pair_info")
-	factory, _ := sdk.AccAddressFromBech32(AddressAstroportFactory)
-	lockdrop, _ := sdk.AccAddressFromBech32(AddressAstroportLockdrop)
+	// 1. get pools - key is terraswap lp address
+	var liquidityPools = make(map[string]poolInfo)
+	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), lockdrop, util.GeneratePrefix("LiquidityPools"), func(key, value []byte) bool {
+		var pi poolInfo
+		util.MustUnmarshalTMJSON(value, &pi)
+		liquidityPools[string(key)] = pi
+		return false
+	})
+
+	// 2. resolve astroport pair & lp held by lockdrop for each migrated pool
+	var pairs = make(map[string]string)
+	var pools = make(map[string]dex.Pool)
+	var lpLocked = make(map[string]sdk.Int)
+	var generatorAstroPerShare = make(map[string]sdk.Dec)
+	for _, pi := range liquidityPools {
+		astroLp := pi.MigrationInfo.AstroportLPToken
+		if astroLp == "" {
+			continue
+		}
 
-	var pairAddr string
-	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), factory, pairPrefix, func(key, value []byte) bool {
-		var p pool
-		util.MustUnmarshalTMJSON(value, &pairAddr)
+		var minter struct {
+			Minter string `json:"minter"`
+		}
+		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+			ContractAddress: astroLp,
+			QueryMsg:        []byte("{\"minter\":{}}"),
+		}, &minter); err != nil {
+			return nil, fmt.Errorf("unable to query minter of %s: %v", astroLp, err)
+		}
+		pairs[astroLp] = minter.Minter
 
+		var p dex.Pool
 		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-			ContractAddress: pairAddr,
+			ContractAddress: minter.Minter,
 			QueryMsg:        []byte("{\"pool\":{}}"),
 		}, &p); err != nil {
-			panic(fmt.Errorf("unable to... %v", err))
+			return nil, fmt.Errorf("unable to query pool of %s: %v", minter.Minter, err)
 		}
+		pools[astroLp] = p
 
-		// filter out those that have aUST/UST/LUNA
-		// technically astroport doesn't have aUST pool but maybe!
-		if isTargetPool(&p) {
-			pm[pairAddr] = p
+		var lpAmount sdk.Int
+		if pi.IsStaked {
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: AddressAstroportGenerator,
+				QueryMsg:        []byte(fmt.Sprintf("{ \"deposit\": { \"lp_token\": \"%s\", \"user\": \"%s\" } }", astroLp, AddressAstroportLockdrop)),
+			}, &lpAmount); err != nil {
+				return nil, fmt.Errorf("unable to query generator deposit of %s: %v", astroLp, err)
+			}
+
+			// bring the pool's generator index up to the snapshot height
+			var pending struct {
+				Pending sdk.Int `json:"pending"`
+			}
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: AddressAstroportGenerator,
+				QueryMsg:        []byte(fmt.Sprintf("{\"pending_token\":{\"lp_token\":\"%s\",\"user\":\"%s\"}}", astroLp, AddressAstroportLockdrop)),
+			}, &pending); err != nil {
+				return nil, fmt.Errorf("unable to query generator pending token of %s: %v", astroLp, err)
+			}
+			perShare := pi.GeneratorAstroPerShare
+			if perShare.IsNil() {
+				perShare = sdk.ZeroDec()
+			}
+			if !lpAmount.IsZero() && !pending.Pending.IsNil() {
+				perShare = perShare.Add(sdk.NewDecFromInt(pending.Pending).QuoInt(lpAmount))
+			}
+			generatorAstroPerShare[astroLp] = perShare
+		} else {
+			balance, err := util.GetCW20Balance(ctx, qs, astroLp, AddressAstroportLockdrop)
+			if err != nil {
+				return nil, err
+			}
+			lpAmount = balance
 		}
+		lpLocked[astroLp] = lpAmount
+	}
 
-		return false
-	})
+	// 3. iterate over all lockdrop positions
+	var claimed = make(map[string]bool)
+	var positions []LockdropPosition
+	var iterErr error
+	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), lockdrop, util.GeneratePrefix("lockup_position"), func(key, value []byte) bool {
+		// keyed by (terraswap lp token, user, duration)
+		var parts [][]byte
+		if parts, iterErr = util.SplitKey(key, 3); iterErr != nil {
+			return true
+		}
+		var terraswapLPAddress, userAddress string
+		if terraswapLPAddress, iterErr = util.AddressFromKey(parts[0]); iterErr != nil {
+			return true
+		}
+		if userAddress, iterErr = util.AddressFromKey(parts[1]); iterErr != nil {
+			return true
+		}
+		if len(parts[2]) != 8 {
+			iterErr = fmt.Errorf("lockup duration %x is not a u64", parts[2])
+			return true
+		}
+		duration := binary.BigEndian.Uint64(parts[2])
 
-	// 2. get pools (and get astroport lp token addr then astroport pair) - key is terraswap lp address
-	var liquidityPools = make(map[string]poolInfo)
-	var lpLockedInGenerator = make(map[string]sdk.Int)
-	poolsPrefix := util.GeneratePrefix("LiquidityPools")
-	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), lockdrop, poolsPrefix, func(key, value []byte) bool {
-		var lpAddr = string(key)
-		var pi poolInfo
+		var li lockupInfo
+		util.MustUnmarshalTMJSON(value, &li)
 
-		lpAddr = string(key)
-		util.MustUnmarshalTMJSON(value, &pi)
+		// If LP transferred is not nil, means the user has withdrawn all LPs after unlock
+		if li.LPUnitsLocked.IsNil() || li.LPUnitsLocked.IsZero() || !li.AstroportLPTransferred.IsNil() {
+			return false
+		}
 
-		liquidityPools[lpAddr] = pi
+		astroLp := liquidityPools[terraswapLPAddress].MigrationInfo.AstroportLPToken
+		if astroLp == "" {
+			return false
+		}
 
-		var lpAmount sdk.Int
-		util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-			ContractAddress: AddressAstroportGenerator,
-			QueryMsg:        []byte(fmt.Sprintf("{ \"deposit\": { \"lp_token\": \"%s\", \"user\": \"%s\" } }", pi.MigrationInfo.AstroportLPToken, AddressAstroportLockdrop)),
-		}, &lpAmount)
+		if _, ok := claimed[userAddress]; !ok {
+			res, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+				ContractAddress: AddressAstroportLockdrop,
+				Key:             append(util.GeneratePrefix("users"), parts[1]...),
+			})
+			if err != nil {
+				iterErr = err
+				return true
+			}
+			var ui lockdropUserInfo
+			if res.Data != nil {
+				util.MustUnmarshalTMJSON(res.Data, &ui)
+			}
+			claimed[userAddress] = ui.LockdropClaimed
+		}
 
-		lpLockedInGenerator[pi.MigrationInfo.AstroportLPToken] = lpAmount
+		// astro_rewards is only stored once the user claimed, compute it otherwise
+		pi := liquidityPools[terraswapLPAddress]
+		astroRewards := li.AstroRewards
+		if astroRewards.IsNil() || astroRewards.IsZero() {
+			astroRewards = lockupIncentives(config, state, pi, li.LPUnitsLocked, duration)
+		}
+		position := LockdropPosition{
+			User:             userAddress,
+			TerraswapLpToken: terraswapLPAddress,
+			AstroportLpToken: astroLp,
+			AstroportPair:    pairs[astroLp],
+			LpUnitsLocked:    li.LPUnitsLocked,
+			Duration:         duration,
+			UnlockTimestamp:  li.UnlockTimestamp,
+			AstroClaimed:     sdk.ZeroInt(),
+			AstroUnclaimed:   astroRewards,
+			GeneratorAstro:   sdk.ZeroInt(),
+		}
+		if !li.GeneratorAstroDebt.IsNil() {
+			// stashed on the position until the LP share is known
+			position.GeneratorAstro = li.GeneratorAstroDebt.Neg()
+		}
+		if claimed[userAddress] {
+			position.AstroClaimed, position.AstroUnclaimed = astroRewards, sdk.ZeroInt()
+		}
+		positions = append(positions, position)
 		return false
 	})
+	if iterErr != nil {
+		return nil, fmt.Errorf("unable to decode lockup positions: %v", iterErr)
+	}
 
-	// 4. Iterate over all lockdrop pos
-	prefix := util.GeneratePrefix("lockup_position")
-	var lockupInfo struct {
-		LPUnitsLocked          sdk.Int `json:"lp_units_locked"`
-		AstroportLPTransferred sdk.Int `json:"astroport_lp_transferred"`
+	// 4. distribute astroport LP held by lockdrop pro-rata to the terraswap LP still locked
+	var totalUnits = make(map[string]sdk.Int)
+	for _, position := range positions {
+		if totalUnits[position.AstroportLpToken].IsNil() {
+			totalUnits[position.AstroportLpToken] = sdk.ZeroInt()
+		}
+		totalUnits[position.AstroportLpToken] = totalUnits[position.AstroportLpToken].Add(position.LpUnitsLocked)
 	}
-	var lpShareHoldings = make(map[string]map[string]sdk.Int)
+	for i := range positions {
+		position := &positions[i]
+		position.AstroportLp = position.LpUnitsLocked.Mul(lpLocked[position.AstroportLpToken]).Quo(totalUnits[position.AstroportLpToken])
 
-	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), lockdrop, prefix, func(key, value []byte) bool {
-		terraswapLPAddress := string(key[2:46])
-		userAddress := string(key[48:92])
+		if perShare, ok := generatorAstroPerShare[position.AstroportLpToken]; ok {
+			position.GeneratorAstro = perShare.MulInt(position.AstroportLp).TruncateInt().Add(position.GeneratorAstro)
+		}
+		if !position.GeneratorAstro.IsPositive() {
+			position.GeneratorAstro = sdk.ZeroInt()
+		}
 
-		util.MustUnmarshalTMJSON(value, &lockupInfo)
-		astroLp := liquidityPools[terraswapLPAddress].MigrationInfo.AstroportLPToken
+		pool := pools[position.AstroportLpToken]
+		shares := dex.GetShareInAssets(pool, position.AstroportLp, pool.TotalShare)
+		for j, asset := range pool.Assets {
+			position.Underlying = append(position.Underlying, dex.Asset{
+				AssetInfo: asset.AssetInfo,
+				Amount:    shares[j],
+			})
+		}
+	}
 
-		if lpShareHoldings[astroLp] == nil {
-			lpShareHoldings[astroLp] = make(map[string]sdk.Int)
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].User != positions[j].User {
+			return positions[i].User < positions[j].User
 		}
-		if lpShareHoldings[astroLp][userAddress].IsNil() {
-			lpShareHoldings[astroLp][userAddress] = sdk.NewInt(0)
+		if positions[i].TerraswapLpToken != positions[j].TerraswapLpToken {
+			return positions[i].TerraswapLpToken < positions[j].TerraswapLpToken
 		}
+		return positions[i].Duration < positions[j].Duration
+	})
 
-		// If LP transferred is not nil, means the user has withdrawn all LPs after unlock
-		if !lockupInfo.LPUnitsLocked.IsNil() && lockupInfo.AstroportLPTransferred.IsNil() {
-			lpShareHoldings[astroLp][userAddress] = lpShareHoldings[astroLp][userAddress].Add(lockupInfo.LPUnitsLocked)
-		}
-		return false
+	app.Logger().Info(fmt.Sprintf("... %d lockdrop positions in %d pools", len(positions), len(pools)))
+	return positions, nil
+}
+
+// lockupIncentives is the lockdrop's ASTRO allocation of a lockup: the pool's share of the incentives,
+// split by lockup weight. Longer lockups weigh more: weight = 1 + (weeks - 1) * multiplier / divider.
+func lockupIncentives(config lockdropConfig, state lockdropState, pi poolInfo, lpUnits sdk.Int, duration uint64) sdk.Int {
+	if config.LockdropIncentives.IsNil() || state.TotalIncentivesShare == 0 || pi.WeightedAmount.IsNil() || pi.WeightedAmount.IsZero() || config.WeeklyDivider == 0 {
+		return sdk.ZeroInt()
+	}
+	weight := sdk.OneDec()
+	if duration > 0 {
+		weight = weight.Add(sdk.NewDec(int64((duration - 1) * config.WeeklyMultiplier)).QuoInt64(int64(config.WeeklyDivider)))
+	}
+	weighted := weight.MulInt(lpUnits).TruncateInt()
+	return sdk.NewIntFromUint64(pi.IncentivesShare).Mul(weighted).Mul(config.LockdropIncentives).
+		Quo(sdk.NewIntFromUint64(state.TotalIncentivesShare).Mul(pi.WeightedAmount))
+}
+
+func rawItem(ctx context.Context, qs wasmtypes.QueryServer, contract string, key string, res interface{}) error {
+	raw, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+		ContractAddress: contract,
+		Key:             []byte(key),
 	})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw.Data, res); err != nil {
+		return fmt.Errorf("unable to parse %s of %s: %v", key, contract, err)
+	}
+	return nil
+}
+
+// ExportAstroportLockdrop returns astroport LP owned by lockdrop users in UST/LUNA/bLUNA pools.
+func ExportAstroportLockdrop(app *app.TerraApp, snapshot util.SnapshotBalanceAggregateMap) (map[string]map[string]map[string]sdk.Int, error) {
+	positions, err := ExportLockdropPositions(app)
+	if err != nil {
+		return nil, err
+	}
 
-	for lp, shares := range lpShareHoldings {
-		totalShares := util.Sum(shares)
-		for addr, share := range shares {
-			shares[addr] = share.Mul(lpLockedInGenerator[lp]).Quo(totalShares)
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	if err := util.SaveDataToFile(fmt.Sprintf("%s/astro-lockdrop-positions", folder), positions); err != nil {
+		return nil, err
+	}
+
+	var lpShareHoldings = make(map[string]map[string]sdk.Int)
+	for _, position := range positions {
+		pool := dex.Pool{Assets: position.Underlying}
+		if !dex.IsTargetPool(&pool) {
+			continue
+		}
+		if lpShareHoldings[position.AstroportLpToken] == nil {
+			lpShareHoldings[position.AstroportLpToken] = make(map[string]sdk.Int)
+		}
+		if lpShareHoldings[position.AstroportLpToken][position.User].IsNil() {
+			lpShareHoldings[position.AstroportLpToken][position.User] = sdk.ZeroInt()
 		}
+		lpShareHoldings[position.AstroportLpToken][position.User] = lpShareHoldings[position.AstroportLpToken][position.User].Add(position.AstroportLp)
 	}
 
 	lpContractHoldings := make(map[string]map[string]map[string]sdk.Int)
@@ -122,15 +362,3 @@ func ExportAstroportLockdrop(app *app.TerraApp, snapshot util.SnapshotBalanceAgg
 
 	return lpContractHoldings, nil
 }
-
-func getShareInAssets(p pool, lpAmount sdk.Int, totalShare sdk.Int) [2]sdk.Int {
-	shareRatio := sdk.ZeroDec()
-	if !totalShare.IsZero() {
-		shareRatio = sdk.NewDecFromInt(lpAmount).Quo(sdk.NewDecFromInt(totalShare))
-	}
-
-	return [2]sdk.Int{
-		shareRatio.MulInt(p.Assets[0].Amount).TruncateInt(),
-		shareRatio.MulInt(p.Assets[1].Amount).TruncateInt(),
-	}
-}
@@ -35,7 +35,9 @@ import (
 	"github.com/terra-money/core/app/export/whitewhale"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
 	"github.com/cosmos/cosmos-sdk/x/bank/types"
+	distrtypes "github.com/cosmos/cosmos-sdk/x/distribution/types"
 	terra "github.com/terra-money/core/app"
 	"github.com/terra-money/core/app/export/mars"
 	"github.com/terra-money/core/app/export/mirror"
@@ -57,11 +59,17 @@ func ExportContracts(app *terra.TerraApp) []types.Balance {
 
 	// // Export anchor
 	aUST := checkWithSs(util.CachedSBA(anchor.ExportAnchorDeposit, "anchor", app, bl))
-	bLunaInCustody := checkWithSs(util.CachedSBA(anchor.ExportbLUNA, "anchor-bluna", app, bl))
+	blunaCache := "anchor-bluna"
+	if anchor.NetBorrowerPositions {
+		blunaCache = "anchor-bluna-net"
+	}
+	bLunaInCustody := checkWithSs(util.CachedSBA(anchor.ExportbLUNA, blunaCache, app, bl))
+	nonTargetCollaterals := checkWithSs(util.CachedSBA(anchor.ExportNonTargetCollaterals, "anchor-non-target-collaterals", app, bl))
+	check(anchor.ReportNonTargetCollaterals(app, nonTargetCollaterals))
 
 	singleStakingSnapshot := make(util.SnapshotBalanceAggregateMap)
 	// Export Compounders
-	compoundedLps, err := exportCompounders(app, snapshotType, singleStakingSnapshot)
+	compoundedLps, err := exportCompounders(app, singleStakingSnapshot)
 	if err != nil {
 		panic(err)
 	}
@@ -83,31 +91,25 @@ func ExportContracts(app *terra.TerraApp) []types.Balance {
 	check(prism.Audit(app, prismSs))
 	prismLoSs := checkWithSs(util.CachedSBA(prism.ExportLimitOrderContract, "prism-limit-order", app, bl))
 	check(prism.AuditLOs(app, prismLoSs))
-	var apertureSs util.SnapshotBalanceAggregateMap
-	if snapshotType == util.Snapshot(util.PreAttack) {
-		apertureSs = checkWithSs(util.CachedSBA(aperture.ExportApertureVaultsPreAttack, "aperture-pre", app, bl))
-	} else {
-		apertureSs = checkWithSs(util.CachedSBA(aperture.ExportApertureVaultsPostAttack, "aperture-post", app, bl))
-	}
+	apertureSs := checkWithSs(util.CachedSBA(aperture.ExportContract(snapshotType), fmt.Sprintf("aperture-%s", snapshotType), app, bl))
 
-	edgeSs := checkWithSs(util.CachedSBA(edge.ExportContract, "edge", app, bl))
+	edgeSs := checkWithSs(util.CachedSBA(edge.ExportContract, "edge-net", app, bl))
 	check(edge.Audit(app, edgeSs))
-	mirrorSs := checkWithSs(util.CachedSBA(mirror.ExportMirrorCdps, "mirror-cdp", app, bl))
+	mirrorSs := checkWithSs(util.CachedSBA(mirror.ExportMirrorCdps, "mirror-cdp-net", app, bl))
 	check(mirror.AuditCdps(app, mirrorSs))
 	mirrorLoSs := checkWithSs(util.CachedSBA(mirror.ExportLimitOrderContract, "mirror-limit-order", app, bl))
 	check(mirror.AuditLOs(app, mirrorLoSs))
 	inkSs := checkWithSs(util.CachedSBA(ink.ExportContract, "ink", app, bl))
-	lunaXSs := checkWithSs(util.CachedSBA(stader.ExportLunaX, "stader", app, bl))
-	staderPoolSs := checkWithSs(util.CachedSBA(stader.ExportPools, "stader-pools", app, bl))
-	staderStakeSs := checkWithSs(util.CachedSBA(stader.ExportStakePlus, "stader-stake-plus", app, bl))
-	staderVaultSs := checkWithSs(util.CachedSBA(stader.ExportVaults, "stader-vaults", app, bl))
+	staderSs := checkWithSs(util.CachedSBA(stader.ExportContract, "stader-dedup", app, bl))
+	check(stader.Audit(app, staderSs))
 	angelSs := checkWithSs(util.CachedSBA(angel.ExportEndowments, "angel", app, bl))
 	randomEarthSs := checkWithSs(util.CachedSBA(randomearth.ExportSettlements, "radomearth", app, bl))
 	starTerraSs := checkWithSs(util.CachedSBA(starterra.ExportIDO, "starterra", app, bl))
 	check(starterra.Audit(app, starTerraSs))
 	starfletSs := checkWithSs(util.CachedSBA(starflet.ExportArbitrageAUST, "starflet", app, bl))
-	pylonSs := checkWithSs(util.CachedSBA(pylon.ExportContract, "pylon", app, bl))
-	marsSs := checkWithSs(util.CachedSBA(mars.ExportContract, "mars", app, bl))
+	pylonSs := checkWithSs(util.CachedSBA(pylon.ExportContract, "pylon-deposits", app, bl))
+	check(pylon.Audit(app, pylonSs))
+	marsSs := checkWithSs(util.CachedSBA(mars.ExportContract, "mars-redbank-net", app, bl))
 	check(mars.Audit(app, marsSs))
 
 	// Export miscellaneous
@@ -128,8 +130,7 @@ func ExportContracts(app *terra.TerraApp) []types.Balance {
 		astroportSnapshot, terraswapSnapshot, loopSnapshot,
 		suberraSs, whiteWhaleSs, kujiraSs, prismSs,
 		prismLoSs, apertureSs, edgeSs, mirrorSs,
-		mirrorLoSs, inkSs, lunaXSs, staderPoolSs,
-		staderStakeSs, staderVaultSs, angelSs,
+		mirrorLoSs, inkSs, staderSs, angelSs,
 		randomEarthSs, starfletSs, flokiSs,
 		flokiRefundsSs, nebulaSs, aliceSs, kineticSs,
 		steakSs, nexusSs, marsSs,
@@ -140,15 +141,22 @@ func ExportContracts(app *terra.TerraApp) []types.Balance {
 	)
 
 	bondedLuna := checkWithSs(util.CachedSBA(native.ExportAllBondedLuna, "bonded-luna", app, bl))
+	stakingRewards := checkWithSs(util.CachedSBA(native.ExportStakingRewards, "staking-rewards", app, bl))
+	// distribution module account holds all outstanding rewards, credited above per delegator
+	distrAddr := authtypes.NewModuleAddress(distrtypes.ModuleName).String()
+	bl.RegisterAddress(util.DenomLUNA, distrAddr)
+	bl.RegisterAddress(util.DenomUST, distrAddr)
 	bl.RegisterAddress(util.DenomLUNA, "terra1fl48vsnmsdzcv85q5d2q4z5ajdha8yu3nln0mh")
 	bl.RegisterAddress(util.DenomLUNA, "terra1tygms3xhhs3yv487phx3dw4a95jn7t7l8l07dr")
 	nativeBalances := checkWithSs(util.CachedSBA(native.ExportAllNativeBalances, "native-balance", app, bl))
+	// written on every run, the native balances above may come from cache
+	check(native.WriteVestingAccounts(app))
 	vestingSs, contractMap, err := generic.ExportVestingContracts(app, bl)
 	if err != nil {
 		panic(err)
 	}
 
-	snapshot = util.MergeSnapshots(snapshot, bondedLuna, nativeBalances, vestingSs)
+	snapshot = util.MergeSnapshots(snapshot, bondedLuna, stakingRewards, nativeBalances, vestingSs)
 	snapshot.ApplyBlackList(bl)
 
 	util.SaveToFile(app, snapshot, "after-protocols")
@@ -203,9 +211,9 @@ func NewBlacklist() util.Blacklist {
 	}
 }
 
-func exportCompounders(app *terra.TerraApp, snaphotType util.Snapshot, snapshot util.SnapshotBalanceAggregateMap) (map[string]map[string]map[string]sdk.Int, error) {
+func exportCompounders(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) (map[string]map[string]map[string]sdk.Int, error) {
 	finalMap := make(map[string]map[string]map[string]sdk.Int)
-	specLps, err := util.CachedMap3(spectrum.ExportSpecVaultLPs, "spectrum", app, snapshot)
+	specLps, err := util.CachedMap3(spectrum.ExportSpecVaultLPs, "spectrum-vaults", app, snapshot)
 	if err != nil {
 		return nil, err
 	}
@@ -219,14 +227,12 @@ func exportCompounders(app *terra.TerraApp, snaphotType util.Snapshot, snapshot
 	for k, v := range apolloLps {
 		finalMap[k] = v
 	}
-	if snaphotType == util.Snapshot(util.PreAttack) {
-		marsLps, err := util.CachedMap3(mars.ExportFieldOfMarsLpTokens, "mars-field", app, snapshot)
-		if err != nil {
-			return nil, err
-		}
-		for k, v := range marsLps {
-			finalMap[k] = v
-		}
+	fieldLps, err := util.CachedMap3(mars.ExportFieldOfMarsLpTokens, "mars-field-net", app, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range fieldLps {
+		finalMap[k] = v
 	}
 	mirrorLps, err := util.CachedMap3(mirror.ExportMirrorLpStakers, "mirror", app, snapshot)
 	if err != nil {
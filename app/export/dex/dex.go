@@ -0,0 +1,287 @@
+package dex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+	wasmkeeper "github.com/terra-money/core/x/wasm/keeper"
+	wasmtypes "github.com/terra-money/core/x/wasm/types"
+)
+
+// Export scans through all pairs of the configured factories, resolves LP holders
+// (wallets, stakers and vaults in contractLpHolders) and refunds the underlying assets
+func Export(app *terra.TerraApp, bl util.Blacklist, cfg Config, contractLpHolders map[string]map[string]map[string]sdk.Int) (util.SnapshotBalanceAggregateMap, error) {
+	app.Logger().Info(fmt.Sprintf("Exporting %s LPs", cfg.Name))
+	ctx := util.PrepCtx(app)
+	qs := util.PrepWasmQueryServer(app)
+
+	app.Logger().Info("... Retrieving all pools")
+	pairs := make(PairMap)
+	for _, factory := range cfg.Factories {
+		if err := GetPairs(ctx, app, factory, cfg, bl, pairs); err != nil {
+			return nil, err
+		}
+	}
+	app.Logger().Info(fmt.Sprintf("...... pool count: %d", len(pairs)))
+
+	app.Logger().Info("... Getting LP holders")
+	lpHolders, err := getLpHolders(ctx, qs, app.WasmKeeper, cfg, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ResolveStakers != nil {
+		app.Logger().Info("... Resolving staking ownership")
+		if err := cfg.ResolveStakers(ctx, app, pairs, lpHolders); err != nil {
+			return nil, err
+		}
+	}
+
+	app.Logger().Info("... Replace LP tokens owned by other vaults")
+	reports, err := ReplaceVaultHoldings(ctx, qs, app.WasmKeeper, lpHolders, contractLpHolders, cfg.VaultEpsilon)
+	if err != nil {
+		return nil, err
+	}
+	saveRoundingReports(app, cfg, reports)
+
+	app.Logger().Info("... Refund LPs")
+	return RefundLps(pairs, lpHolders), nil
+}
+
+func saveRoundingReports(app *terra.TerraApp, cfg Config, reports []util.RoundingReport) {
+	var data [][]string
+	for _, r := range reports {
+		data = append(data, []string{r.Token, r.Contract, fmt.Sprintf("%d", r.Depth), r.Amount.String(), r.Distributed.String(), r.Dust.String()})
+	}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/%s-rounding.csv", folder, strings.ToLower(cfg.Name)), []string{"token", "contract", "depth", "amount", "distributed", "dust"}, data)
+}
+
+// GetPairs iterates over pair_info of a factory and collects every target pool with a non-zero supply.
+// Pools may have any number of assets. LP withdrawals are pro-rata to the reserves for stable pairs
+// as well, amplification only affects swaps.
+func GetPairs(ctx context.Context, app *terra.TerraApp, factoryAddr string, cfg Config, bl util.Blacklist, pairs PairMap) error {
+	qs := util.PrepWasmQueryServer(app)
+	factory, err := sdk.AccAddressFromBech32(factoryAddr)
+	if err != nil {
+		return err
+	}
+
+	pairPrefix := util.GeneratePrefix("pair_info")
+	var iterErr error
+	app.WasmKeeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), factory, pairPrefix, func(key, value []byte) bool {
+		pair := Pair{Type: PairTypeXyk}
+		switch cfg.PairEncoding {
+		case PairAddress:
+			util.MustUnmarshalTMJSON(value, &pair.Address)
+		default:
+			var info pairInfoCanonical
+			util.MustUnmarshalTMJSON(value, &info)
+			pair.Address = sdk.AccAddress(info.ContractAddr).String()
+			pair.LiquidityToken = sdk.AccAddress(info.LiquidityToken).String()
+		}
+
+		// register all pairs as blacklist.
+		RegisterPairInBlacklist(bl, pair.Address)
+
+		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+			ContractAddress: pair.Address,
+			QueryMsg:        []byte("{\"pool\":{}}"),
+		}, &pair.Pool); err != nil {
+			if cfg.SkipIrregularPairs {
+				return false
+			}
+			iterErr = fmt.Errorf("unable to query pool of %s: %v", pair.Address, err)
+			return true
+		}
+
+		// skip non-target pools
+		if !IsTargetPool(&pair.Pool) || pair.Pool.TotalShare.IsNil() || pair.Pool.TotalShare.IsZero() {
+			return false
+		}
+
+		if cfg.PairEncoding == PairAddress {
+			var info pairInfo
+			if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+				ContractAddress: pair.Address,
+				QueryMsg:        []byte("{\"pair\":{}}"),
+			}, &info); err != nil {
+				iterErr = fmt.Errorf("unable to query pair of %s: %v", pair.Address, err)
+				return true
+			}
+			pair.LiquidityToken = info.LiquidityToken
+			pair.Type = pairTypeName(info.PairType)
+		}
+
+		if pair.Type == PairTypeStable {
+			app.Logger().Info(fmt.Sprintf("...... stable pair %s, %d assets", pair.Address, len(pair.Pool.Assets)))
+		}
+
+		pairs[pair.Address] = pair
+		return false
+	})
+	return iterErr
+}
+
+func getLpHolders(ctx context.Context, qs wasmtypes.QueryServer, keeper wasmkeeper.Keeper, cfg Config, pairs PairMap) (LpHoldersMap, error) {
+	lpHolders := make(LpHoldersMap)
+
+	// read from previous export
+	if cfg.LpHoldersFile != "" {
+		data, err := os.ReadFile(cfg.LpHoldersFile)
+		if err == nil {
+			if err = json.Unmarshal(data, &lpHolders); err != nil {
+				return nil, err
+			}
+		}
+		if len(lpHolders) != 0 {
+			return lpHolders, nil
+		}
+	}
+
+	for _, pair := range pairs {
+		balanceMap := make(util.BalanceMap)
+		if err := util.GetCW20AccountsAndBalances(ctx, keeper, pair.LiquidityToken, balanceMap); err != nil {
+			return nil, fmt.Errorf("failed to iterate over LP token owners: %v", err)
+		}
+		lpHolders[pair.LiquidityToken] = balanceMap
+	}
+	return lpHolders, nil
+}
+
+// ReplaceVaultHoldings replaces LP tokens held by vaults / compounders with their users' shares.
+// Vaults holding shares of other vaults are followed recursively until only end holders remain,
+// as are contracts issuing a cw20 share token backed by the LP they hold: the LP is converted
+// into shares at the contract's LP / share supply ratio and pushed down to the share holders.
+func ReplaceVaultHoldings(ctx context.Context, qs wasmtypes.QueryServer, keeper wasmkeeper.Keeper, lpHolders LpHoldersMap, contractLpHolders map[string]map[string]map[string]sdk.Int, epsilon sdk.Int) ([]util.RoundingReport, error) {
+	graph := make(util.OwnershipGraph)
+	for contract, holdings := range contractLpHolders {
+		graph[contract] = holdings
+	}
+	shares, err := discoverShareTokens(ctx, qs, keeper, graph, lpHolders)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []util.RoundingReport
+	for lpAddr, lpHolding := range lpHolders {
+		replaced := false
+		for vaultAddr, vaultAmount := range lpHolding {
+			if share, ok := shares[vaultAddr]; ok && share.Underlying == lpAddr {
+				replaced = true
+				continue
+			}
+			userHoldings, isVault := graph[vaultAddr][lpAddr]
+			if !isVault || vaultAmount.IsNil() || !vaultAmount.IsPositive() {
+				continue
+			}
+			if err := util.AlmostEqual(fmt.Sprintf("vault %s amount inconsistent", vaultAddr), vaultAmount, util.Sum(userHoldings), epsilon); err != nil {
+				return nil, err
+			}
+			replaced = true
+		}
+		if !replaced {
+			continue
+		}
+
+		resolved, lpReports, err := graph.ResolveShares(lpAddr, lpHolding, shares)
+		if err != nil {
+			return nil, err
+		}
+		lpHolders[lpAddr] = resolved
+		reports = append(reports, lpReports...)
+		util.AssertCw20Supply(ctx, qs, lpAddr, resolved)
+	}
+	return reports, nil
+}
+
+// discoverShareTokens finds contracts holding LP that are not compounders in the graph but cw20 tokens
+// themselves, e.g. a vault share or a wrapper. Their holders are added to the graph under the share token.
+// A share token backs a single LP token, contracts holding more than one keep the others.
+func discoverShareTokens(ctx context.Context, qs wasmtypes.QueryServer, keeper wasmkeeper.Keeper, graph util.OwnershipGraph, lpHolders LpHoldersMap) (util.ShareTokens, error) {
+	var lps []string
+	for lpAddr := range lpHolders {
+		lps = append(lps, lpAddr)
+	}
+	sort.Strings(lps)
+
+	shares := make(util.ShareTokens)
+	for _, lpAddr := range lps {
+		var holders []string
+		for holder := range lpHolders[lpAddr] {
+			holders = append(holders, holder)
+		}
+		sort.Strings(holders)
+
+		for _, holder := range holders {
+			amount := lpHolders[lpAddr][holder]
+			if _, isVault := graph[holder][lpAddr]; isVault || amount.IsNil() || !amount.IsPositive() {
+				continue
+			}
+			if _, ok := shares[holder]; ok {
+				continue
+			}
+			addr, err := sdk.AccAddressFromBech32(holder)
+			if err != nil {
+				continue
+			}
+			if _, err := keeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), addr); err != nil {
+				continue
+			}
+			// contracts that are not cw20 tokens fail the token_info query
+			supply, err := util.GetCW20TotalSupply(ctx, qs, holder)
+			if err != nil || supply.IsNil() || !supply.IsPositive() {
+				continue
+			}
+
+			balances := make(util.BalanceMap)
+			if err := util.GetCW20AccountsAndBalances(ctx, keeper, holder, balances); err != nil {
+				return nil, fmt.Errorf("failed to iterate over share token %s owners: %v", holder, err)
+			}
+			holdings := make(map[string]map[string]sdk.Int)
+			for token, users := range graph[holder] {
+				holdings[token] = users
+			}
+			holdings[holder] = balances
+			graph[holder] = holdings
+			shares[holder] = util.ShareToken{
+				Underlying: lpAddr,
+				Ratio:      sdk.NewDecFromInt(amount).QuoInt(supply),
+			}
+		}
+	}
+	return shares, nil
+}
+
+// RefundLps converts every LP holding into its share of the pool's target assets
+func RefundLps(pairs PairMap, lpHolders LpHoldersMap) util.SnapshotBalanceAggregateMap {
+	var finalBalance = make(util.SnapshotBalanceAggregateMap)
+	for _, pair := range pairs {
+		pool := pair.Pool
+
+		// iterate over LP holders, calculate how much is to be refunded
+		for userAddr, lpBalance := range lpHolders[pair.LiquidityToken] {
+			refunds := GetShareInAssets(pool, lpBalance, pool.TotalShare)
+
+			for i, a := range pool.Assets {
+				denom, ok := CoalesceToBalanceDenom(PickDenomOrContractAddress(a.AssetInfo))
+				if !ok || refunds[i].IsZero() {
+					continue
+				}
+				finalBalance.AppendOrAddBalance(userAddr, util.SnapshotBalance{
+					Denom:   denom,
+					Balance: refunds[i],
+				})
+			}
+		}
+	}
+	return finalBalance
+}
@@ -0,0 +1,23 @@
+package dex
+
+import "encoding/json"
+
+const (
+	PairTypeXyk    = "xyk"
+	PairTypeStable = "stable"
+)
+
+// pairTypeName returns the name of an Astroport pair type, e.g. {"stable":{}} => "stable"
+func pairTypeName(pairType map[string]json.RawMessage) string {
+	for name, params := range pairType {
+		// custom pair types carry their name as the value
+		if name == "custom" {
+			var custom string
+			if err := json.Unmarshal(params, &custom); err == nil {
+				return custom
+			}
+		}
+		return name
+	}
+	return PairTypeXyk
+}
@@ -0,0 +1,85 @@
+package dex
+
+import (
+	"context"
+	"encoding/json"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+)
+
+// PairEncoding is how a factory stores its pair_info entries
+type PairEncoding int
+
+const (
+	// value is the full pair info with canonical (base64) addresses (Terraswap, Loop and most forks)
+	PairInfoCanonical PairEncoding = iota
+	// value is the human address of the pair contract (Astroport)
+	PairAddress
+)
+
+type (
+	// Config describes a constant-product DEX. A Terraswap fork only needs a new Config.
+	Config struct {
+		Name         string
+		Factories    []string
+		PairEncoding PairEncoding
+
+		// skip pairs whose pool query fails instead of failing the export
+		SkipIrregularPairs bool
+
+		// epsilon allowed between a vault's LP balance and the sum of its users' shares
+		VaultEpsilon sdk.Int
+
+		// optional file with precomputed lp => user => amount, skips iterating LP tokens
+		LpHoldersFile string
+
+		// optional hook replacing LP held by staking contracts with the stakers
+		ResolveStakers func(ctx context.Context, app *terra.TerraApp, pairs PairMap, lpHolders LpHoldersMap) error
+	}
+
+	Asset struct {
+		AssetInfo AssetInfo `json:"info"`
+		Amount    sdk.Int   `json:"amount"`
+	}
+
+	AssetInfo struct {
+		Token *struct {
+			ContractAddr string `json:"contract_addr"`
+		} `json:"token,omitempty"`
+		NativeToken *struct {
+			Denom string `json:"denom"`
+		} `json:"native_token,omitempty"`
+	}
+
+	Pool struct {
+		Assets     []Asset `json:"assets"`
+		TotalShare sdk.Int `json:"total_share"`
+	}
+
+	// pair_info as stored by Terraswap style factories
+	pairInfoCanonical struct {
+		AssetInfos     []AssetInfo `json:"asset_infos"`
+		ContractAddr   []byte      `json:"contract_addr"`
+		LiquidityToken []byte      `json:"liquidity_token"`
+	}
+
+	// pair query response
+	pairInfo struct {
+		AssetInfos     []AssetInfo                `json:"asset_infos"`
+		ContractAddr   string                     `json:"contract_addr"`
+		LiquidityToken string                     `json:"liquidity_token"`
+		PairType       map[string]json.RawMessage `json:"pair_type,omitempty"`
+	}
+
+	Pair struct {
+		Address        string
+		LiquidityToken string
+		Type           string
+		Pool           Pool
+	}
+
+	PairMap      map[string]Pair            // pair addr => pair
+	LpHoldersMap map[string]util.BalanceMap // lp => user => amount
+)
@@ -0,0 +1,83 @@
+package dex
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/terra-money/core/app/export/util"
+)
+
+// see if pool contains any of LUNA, UST, AUST or a LUNA derivative
+func IsTargetPool(p *Pool) bool {
+	for _, a := range p.Assets {
+		if _, ok := CoalesceToBalanceDenom(PickDenomOrContractAddress(a.AssetInfo)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func PickDenomOrContractAddress(asset AssetInfo) string {
+	if asset.Token != nil {
+		return asset.Token.ContractAddr
+	}
+
+	if asset.NativeToken != nil {
+		return asset.NativeToken.Denom
+	}
+
+	panic("unknown denom")
+}
+
+func CoalesceToBalanceDenom(assetName string) (string, bool) {
+	switch assetName {
+	case "uusd":
+		return util.DenomUST, true
+	case "uluna":
+		return util.DenomLUNA, true
+	case util.AddressAUST:
+		return util.DenomAUST, true
+	case util.AddressBLUNA:
+		return util.DenomBLUNA, true
+	case util.AddressSTLUNA:
+		return util.DenomSTLUNA, true
+	case util.AddressCLUNA:
+		return util.DenomCLUNA, true
+	case util.AddressPLUNA:
+		return util.DenomPLUNA, true
+	case util.AddressNLUNA:
+		return util.DenomNLUNA, true
+	case util.AddressSTEAK:
+		return util.DenomSTEAK, true
+	case util.AddressLUNAX:
+		return util.DenomLUNAX, true
+	}
+
+	return "", false
+}
+
+// GetShareInAssets returns the amount of each pool asset owned by lpAmount
+func GetShareInAssets(p Pool, lpAmount sdk.Int, totalShare sdk.Int) []sdk.Int {
+	shareRatio := sdk.ZeroDec()
+	if !totalShare.IsZero() {
+		shareRatio = sdk.NewDecFromInt(lpAmount).Quo(sdk.NewDecFromInt(totalShare))
+	}
+
+	shares := make([]sdk.Int, len(p.Assets))
+	for i, a := range p.Assets {
+		shares[i] = shareRatio.MulInt(a.Amount).TruncateInt()
+	}
+	return shares
+}
+
+// RegisterPairInBlacklist registers a pair contract for every tracked denom,
+// the pair's reserves are attributed to LP holders instead
+func RegisterPairInBlacklist(bl util.Blacklist, pairAddr string) {
+	bl.RegisterAddress(util.DenomAUST, pairAddr)
+	bl.RegisterAddress(util.DenomUST, pairAddr)
+	bl.RegisterAddress(util.DenomLUNA, pairAddr)
+	bl.RegisterAddress(util.DenomBLUNA, pairAddr)
+	bl.RegisterAddress(util.DenomSTLUNA, pairAddr)
+	bl.RegisterAddress(util.DenomPLUNA, pairAddr)
+	bl.RegisterAddress(util.DenomCLUNA, pairAddr)
+	bl.RegisterAddress(util.DenomSTEAK, pairAddr)
+	bl.RegisterAddress(util.DenomLUNAX, pairAddr)
+}
@@ -3,11 +3,14 @@ package edge
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	// stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
 	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/anchor"
 	"github.com/terra-money/core/app/export/lido"
 	"github.com/terra-money/core/app/export/prism"
 	"github.com/terra-money/core/app/export/stader"
@@ -29,88 +32,328 @@ var (
 		// pLuna
 		prism.PrismPLuna,
 	}
+
+	// edge state per height, computed once for the export and its audit
+	states = make(map[int64]edgeState)
 )
 
+// ExportContract credits suppliers of every target market their share of the market, net of
+// what they borrowed. Debt is first netted against supply of the same asset, the remainder is
+// valued in UST and taken from the user's other supplied assets. LSDs are credited in their own
+// denom and resolved to LUNA later with the direct holders. Non-target markets are only reported.
 func ExportContract(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
 	app.Logger().Info("Exporting Edge Protocol")
+	state, err := getEdgeState(app)
+	if err != nil {
+		return nil, err
+	}
+	for _, market := range state.markets {
+		bl.RegisterAddress(util.MapContractToDenom(market.Underlying), EdgeProtocolPool)
+	}
+	if state.badDebt.IsPositive() {
+		app.Logger().Info(fmt.Sprintf("... edge bad debt worth %s uusd", state.badDebt.TruncateInt()))
+	}
+	return state.snapshot, nil
+}
+
+// Audit checks every target asset: borrowed assets left the pool, so credits are the pool balance
+// plus debt netted against other assets or left uncovered, minus supply used to cover other assets' debt.
+func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error {
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+	state, err := getEdgeState(app)
+	if err != nil {
+		return err
+	}
+
+	for _, market := range state.markets {
+		var balance sdk.Int
+		if strings.Contains(market.Underlying, "terra") {
+			balance, err = util.GetCW20Balance(ctx, q, market.Underlying, EdgeProtocolPool)
+		} else {
+			balance, err = util.GetNativeBalance(ctx, app.BankKeeper, market.Underlying, EdgeProtocolPool)
+		}
+		if err != nil {
+			return err
+		}
+		denom := util.MapContractToDenom(market.Underlying)
+		expected := balance.Add(orZero(state.adjustments[market.Underlying]))
+		if err := util.AlmostEqual(fmt.Sprintf("edge: %s", denom), snapshot.SumOfDenom(denom), expected, sdk.NewInt(100000)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type edgeState struct {
+	markets  []Market
+	snapshot util.SnapshotBalanceAggregateMap
+	// uusd value of debt not covered by supplied assets
+	badDebt sdk.Dec
+	// underlying => debt not netted against the same asset, minus supply used to cover other assets' debt
+	adjustments map[string]sdk.Int
+}
+
+// getEdgeState reads supply and debt of every user in target markets and nets them.
+// The state is computed once per height.
+func getEdgeState(app *terra.TerraApp) (edgeState, error) {
+	if state, ok := states[app.LastBlockHeight()]; ok {
+		return state, nil
+	}
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
 
 	markets, err := getMarkets(ctx, q)
 	if err != nil {
-		return nil, err
+		return edgeState{}, err
+	}
+	prices, err := getPrices(app)
+	if err != nil {
+		return edgeState{}, err
 	}
-	holdings := make(map[string]map[string]sdk.Int)
+
+	// Assigning insurance fund to protocol admin
+	poolAddr, err := sdk.AccAddressFromBech32(EdgeProtocolPool)
+	if err != nil {
+		return edgeState{}, err
+	}
+	info, err := app.WasmKeeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), poolAddr)
+	if err != nil {
+		return edgeState{}, err
+	}
+
+	state := edgeState{
+		snapshot:    make(util.SnapshotBalanceAggregateMap),
+		badDebt:     sdk.ZeroDec(),
+		adjustments: make(map[string]sdk.Int),
+	}
+	// user => underlying => amount
+	supplied := make(map[string]map[string]sdk.Int)
+	borrowed := make(map[string]map[string]sdk.Int)
+	var skipped [][]string
 	for _, market := range markets {
-		// Skip not whitelisted tokens
 		if !contains(EdgeProtocolTokens, market.Underlying) {
+			tvl := ""
+			if price, err := getOraclePrice(ctx, q, market.Underlying); err != nil {
+				app.Logger().Error(fmt.Sprintf("... edge market %s not priced: %v", market.Underlying, err))
+			} else {
+				tvl = price.MulInt(market.TotalAmount).TruncateInt().String()
+			}
+			skipped = append(skipped, []string{
+				market.Underlying,
+				market.TotalAmount.String(),
+				orZero(market.TotalDebt).String(),
+				market.InsuranceAmount.TruncateInt().String(),
+				tvl,
+			})
 			continue
 		}
-		totalSupply, err := util.GetCW20TotalSupply(ctx, q, market.Etoken)
+		state.markets = append(state.markets, market)
+
+		// Insurance fund belongs to the protocol
+		supply, err := getShares(ctx, app, q, market.Etoken, market.TotalAmount.Sub(market.InsuranceAmount.TruncateInt()))
 		if err != nil {
-			return nil, err
+			return edgeState{}, err
 		}
-		accountBalances := make(map[string]sdk.Int)
-		err = util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, market.Etoken, accountBalances)
-		if err != nil {
-			return nil, err
+		supply[info.Admin] = orZero(supply[info.Admin]).Add(market.InsuranceAmount.TruncateInt())
+		addTo(supplied, supply, market.Underlying)
+
+		if market.Dtoken == "" {
+			if !orZero(market.TotalDebt).IsZero() {
+				return edgeState{}, fmt.Errorf("edge: market %s has %s debt but no debt token", market.Underlying, market.TotalDebt)
+			}
+			continue
 		}
-		holding := make(map[string]sdk.Int)
-		for k, v := range accountBalances {
-			// Insurance fund belongs to the protocol
-			holding[k] = v.Mul(market.TotalAmount.Sub(market.InsuranceAmount.TruncateInt())).Quo(totalSupply)
+		debt, err := getShares(ctx, app, q, market.Dtoken, orZero(market.TotalDebt))
+		if err != nil {
+			return edgeState{}, err
 		}
+		addTo(borrowed, debt, market.Underlying)
+	}
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i][0] < skipped[j][0] })
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/edge-skipped-markets.csv", folder), []string{"underlying", "total_credit", "total_debt", "total_insurance", "tvl_uusd"}, skipped)
 
-		// Assigning insurance fund to protocol admin
-		poolAddr, err := sdk.AccAddressFromBech32(EdgeProtocolPool)
-		if err != nil {
-			return nil, err
+	var borrowers [][]string
+	for addr, supply := range supplied {
+		debt := borrowed[addr]
+		if len(debt) > 0 {
+			gross := make(map[string]sdk.Int)
+			for asset, amount := range supply {
+				gross[asset] = amount
+			}
+			state.badDebt = state.badDebt.Add(netDebt(supply, debt, prices, state.adjustments))
+			for _, asset := range EdgeProtocolTokens {
+				if _, ok := gross[asset]; ok || !orZero(debt[asset]).IsZero() {
+					borrowers = append(borrowers, []string{addr, asset, orZero(gross[asset]).String(), orZero(debt[asset]).String(), orZero(supply[asset]).String()})
+				}
+			}
 		}
-		info, err := app.WasmKeeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), poolAddr)
-		if err != nil {
-			return nil, err
+		for asset, amount := range supply {
+			if amount.IsPositive() {
+				state.snapshot.AppendOrAddBalance(addr, util.SnapshotBalance{
+					Denom:   util.MapContractToDenom(asset),
+					Balance: amount,
+				})
+			}
+		}
+	}
+	// borrowers without any supply are fully bad debt
+	for addr, debt := range borrowed {
+		if _, ok := supplied[addr]; !ok {
+			state.badDebt = state.badDebt.Add(netDebt(map[string]sdk.Int{}, debt, prices, state.adjustments))
+			for asset, amount := range debt {
+				borrowers = append(borrowers, []string{addr, asset, "0", amount.String(), "0"})
+			}
 		}
-		holding[info.Admin] = market.InsuranceAmount.TruncateInt()
-		holdings[market.Underlying] = holding
-		bl.RegisterAddress(util.MapContractToDenom(market.Underlying), EdgeProtocolPool)
 	}
+	sort.Slice(borrowers, func(i, j int) bool {
+		if borrowers[i][0] == borrowers[j][0] {
+			return borrowers[i][1] < borrowers[j][1]
+		}
+		return borrowers[i][0] < borrowers[j][0]
+	})
+	util.ToCsv(fmt.Sprintf("%s/edge-borrowers.csv", folder), []string{"address", "underlying", "supplied", "borrowed", "net"}, borrowers)
 
-	snapshot := make(util.SnapshotBalanceAggregateMap)
-	for asset, holding := range holdings {
-		for addr, b := range holding {
-			snapshot[addr] = append(snapshot[addr], util.SnapshotBalance{
-				Denom:   util.MapContractToDenom(asset),
-				Balance: b,
-			})
+	states[app.LastBlockHeight()] = state
+	return state, nil
+}
+
+// netDebt subtracts debt from supply in place and returns the uusd value of the uncovered debt.
+// Debt left after netting against the same asset is added to adjustments, supply used to cover
+// it is subtracted. Assets without a price are neither valued nor used to cover debt.
+func netDebt(supply map[string]sdk.Int, debt map[string]sdk.Int, prices map[string]sdk.Dec, adjustments map[string]sdk.Int) sdk.Dec {
+	residual := sdk.ZeroDec()
+	for asset, amount := range debt {
+		left := amount
+		if s, ok := supply[asset]; ok {
+			netted := sdk.MinInt(s, amount)
+			supply[asset] = s.Sub(netted)
+			left = amount.Sub(netted)
+		}
+		adjustments[asset] = orZero(adjustments[asset]).Add(left)
+		if price := prices[asset]; !price.IsNil() && price.IsPositive() {
+			residual = residual.Add(price.MulInt(left))
 		}
 	}
-	return snapshot, nil
+	for _, asset := range EdgeProtocolTokens {
+		s, ok := supply[asset]
+		if !residual.IsPositive() {
+			break
+		}
+		price := prices[asset]
+		if !ok || !s.IsPositive() || price.IsNil() || !price.IsPositive() {
+			continue
+		}
+		used := s
+		if value := price.MulInt(s); value.LTE(residual) {
+			residual = residual.Sub(value)
+		} else {
+			used = sdk.MinInt(s, residual.Quo(price).Ceil().TruncateInt())
+			residual = sdk.ZeroDec()
+		}
+		supply[asset] = s.Sub(used)
+		adjustments[asset] = orZero(adjustments[asset]).Sub(used)
+	}
+	return residual
 }
 
-func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error {
+// getOraclePrice returns the uusd price of an asset from the oracle of the edge pool
+func getOraclePrice(ctx context.Context, q wasmtypes.QueryServer, asset string) (sdk.Dec, error) {
+	var config struct {
+		Oracle string `json:"oracle"`
+	}
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: EdgeProtocolPool,
+		QueryMsg:        []byte("{\"config\":{}}"),
+	}, &config); err != nil {
+		return sdk.Dec{}, err
+	}
+	var price struct {
+		Rate sdk.Dec `json:"rate"`
+	}
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: config.Oracle,
+		QueryMsg:        []byte(fmt.Sprintf("{\"price\":{\"base\":\"%s\",\"quote\":\"uusd\"}}", asset)),
+	}, &price); err != nil {
+		return sdk.Dec{}, err
+	}
+	return price.Rate, nil
+}
+
+// getPrices returns the uusd value of one unit of every target asset
+func getPrices(app *terra.TerraApp) (map[string]sdk.Dec, error) {
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
-	for _, token := range EdgeProtocolTokens {
-		var balance sdk.Int
-		var err error
-		if strings.Contains(token, "terra") {
-			balance, err = util.GetCW20Balance(ctx, q, token, EdgeProtocolPool)
-			if err != nil {
-				return err
-			}
-		} else {
-			balance, err = util.GetNativeBalance(ctx, app.BankKeeper, token, EdgeProtocolPool)
-			if err != nil {
-				return err
-			}
-		}
-		denom := util.MapContractToDenom(token)
-		err = util.AlmostEqual(fmt.Sprintf("edge: %s", denom), snapshot.SumOfDenom(denom), balance, sdk.NewInt(100000))
-		if err != nil {
-			return err
+
+	lunaPrice, err := app.OracleKeeper.GetLunaExchangeRate(sdk.UnwrapSDKContext(ctx), util.DenomUST)
+	if err != nil {
+		return nil, err
+	}
+	aUstRate, err := anchor.GetAUstExchangeRate(app)
+	if err != nil {
+		return nil, err
+	}
+	lidoState, err := lido.GetExchangeRates(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	lunaXRate, err := stader.GetLunaXExchangeRate(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	cLunaRate, err := prism.GetCLunaExchangeRate(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]sdk.Dec{
+		util.DenomUST:  sdk.OneDec(),
+		util.AUST:      aUstRate,
+		util.DenomLUNA: lunaPrice,
+		lido.BLuna:     lidoState.BLunaExchangeRate.Mul(lunaPrice),
+		lido.StLuna:    lidoState.StLunaExchangeRate.Mul(lunaPrice),
+		stader.LunaX:   lunaXRate.Mul(lunaPrice),
+		// pLUNA is swapped 1:1 to cLUNA on resolution
+		prism.PrismPLuna: cLunaRate.Mul(lunaPrice),
+	}, nil
+}
+
+// getShares splits total among holders of token pro-rata to their balance
+func getShares(ctx context.Context, app *terra.TerraApp, q wasmtypes.QueryServer, token string, total sdk.Int) (map[string]sdk.Int, error) {
+	totalSupply, err := util.GetCW20TotalSupply(ctx, q, token)
+	if err != nil {
+		return nil, err
+	}
+	accountBalances := make(map[string]sdk.Int)
+	err = util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, token, accountBalances)
+	if err != nil {
+		return nil, err
+	}
+	shares := make(map[string]sdk.Int)
+	if totalSupply.IsZero() {
+		return shares, nil
+	}
+	for k, v := range accountBalances {
+		shares[k] = v.Mul(total).Quo(totalSupply)
+	}
+	return shares, nil
+}
+
+func addTo(m map[string]map[string]sdk.Int, holdings map[string]sdk.Int, asset string) {
+	for addr, amount := range holdings {
+		if m[addr] == nil {
+			m[addr] = make(map[string]sdk.Int)
 		}
+		m[addr][asset] = orZero(m[addr][asset]).Add(amount)
 	}
-	return nil
+}
+
+func orZero(i sdk.Int) sdk.Int {
+	if i.IsNil() {
+		return sdk.ZeroInt()
+	}
+	return i
 }
 
 func contains(a []string, i string) bool {
@@ -151,7 +394,9 @@ func getAllAccounts(ctx context.Context, q wasmtypes.QueryServer) ([]string, err
 type Market struct {
 	Underlying      string  `json:"underlying"`
 	Etoken          string  `json:"etoken_addr"`
+	Dtoken          string  `json:"dtoken_addr"`
 	TotalAmount     sdk.Int `json:"total_credit"`
+	TotalDebt       sdk.Int `json:"total_debt"`
 	InsuranceAmount sdk.Dec `json:"total_insurance"`
 }
 
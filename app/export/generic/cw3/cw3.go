@@ -5,16 +5,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	terra "github.com/terra-money/core/app"
 	"github.com/terra-money/core/app/export/generic/common"
 	util "github.com/terra-money/core/app/export/util"
+	wasmkeeper "github.com/terra-money/core/x/wasm/keeper"
 )
 
+// nested multisigs (a voter that is itself a cw3) need another pass to push
+// their share down. this caps the number of passes in case of cycles.
+const maxSplitPasses = 5
+
 type Cw3InitMsg struct {
+	// fixed multisig (cw3-fixed-multisig)
 	Voters []Voter `json:"voters"`
+	// flex multisig (cw3-flex-multisig), voters live in a cw4-group
+	GroupAddr string `json:"group_addr"`
 }
 
 type Voter struct {
@@ -22,15 +31,35 @@ type Voter struct {
 	Weight  int64  `json:"weight"`
 }
 
+// denoms a multisig may hold once LP and LSD resolution has run
+var resolvedDenoms = map[string]bool{
+	util.DenomUST:  true,
+	util.DenomLUNA: true,
+	util.DenomAUST: true,
+}
+
+// splitRecord is one line of the cw3 split report
+type splitRecord struct {
+	Multisig    string
+	Denom       string
+	Held        sdk.Int
+	Distributed sdk.Int
+	Dust        sdk.Int
+	Note        string
+}
+
 // Export CW3 and other treasuries
-// For genesis snapshot, we split CW3 holdings for UST, aUST LUNA to all voters
-// We missed other staking derivatives, LP and lockdrop holdings
-// For the airdrop fix, we will index everything and remove what we have already airdropped
+// Fixed multisigs carry their voters in the init msg, flex multisigs point to a cw4-group
+// whose members are read from storage at the snapshot height.
+// Must run after LP and LSD resolution: the multisig's position in the snapshot already
+// contains its LP underlying and LSDs converted to LUNA, and all of it is split to voters by weight.
+// Every held denom is split, unresolved ones are flagged in cw3-splits.csv.
+// Truncation dust is not distributed, it is reported in cw3-splits.csv.
 func ExportCW3(app *terra.TerraApp, contractsMap common.ContractsMap, snapshot util.SnapshotBalanceAggregateMap, bl util.Blacklist) error {
-	ctx := util.PrepCtx(app)
-	qs := util.PrepWasmQueryServer(app)
+	app.Logger().Info("Splitting CW3 multisig holdings")
+	ctx := sdk.UnwrapSDKContext(util.PrepCtx(app))
 
-	contractBalanceMap := make(map[string]map[string]sdk.Int)
+	multisigs := make(map[string][]Voter)
 	for addr, ci := range contractsMap {
 		var initmsg Cw3InitMsg
 		if err := json.Unmarshal(ci.InitMsg, &initmsg); err != nil {
@@ -38,73 +67,195 @@ func ExportCW3(app *terra.TerraApp, contractsMap common.ContractsMap, snapshot u
 			continue
 		}
 
-		if len(initmsg.Voters) == 0 {
+		voters, err := getVoters(ctx, app.WasmKeeper, initmsg)
+		if err != nil {
+			return err
+		}
+		if len(voters) == 0 {
 			continue
 		}
+		multisigs[addr] = voters
+	}
 
-		// register this contract in blacklist map
-		bl.RegisterAddress(util.DenomUST, addr)
-		bl.RegisterAddress(util.DenomLUNA, addr)
-		bl.RegisterAddress(util.DenomAUST, addr)
+	// sort for deterministic output across runs
+	var addresses []string
+	for addr := range multisigs {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
 
-		voters := initmsg.Voters
+	var records []splitRecord
+	for pass := 0; pass < maxSplitPasses; pass++ {
+		moved := false
+		for _, addr := range addresses {
+			holdings := multisigPosition(snapshot, addr)
+			if len(holdings) == 0 {
+				continue
+			}
+			moved = true
 
-		addrr, _ := sdk.AccAddressFromBech32(addr)
-		nativeBalance := app.BankKeeper.GetAllBalances(sdk.UnwrapSDKContext(ctx), addrr)
-		ustBalance := nativeBalance.AmountOf("uusd")
-		lunaBalance := nativeBalance.AmountOf("uluna")
-		aUSTBalance, _ := util.GetCW20Balance(ctx, qs, util.AddressAUST, addr)
+			voters := multisigs[addr]
+			for _, b := range holdings {
+				// register this contract in blacklist map
+				bl.RegisterAddress(b.Denom, addr)
+				// derivatives left unresolved are still split as is, and flagged in the report
+				var note string
+				if !resolvedDenoms[b.Denom] {
+					app.Logger().Error(fmt.Sprintf("multisig %s holds unresolved denom %s: %s", addr, b.Denom, b.Balance))
+					note = "unresolved denom"
+				}
+				shares, dust := splitByWeight(b.Balance, voters)
+				for voter, amount := range shares {
+					snapshot.AppendOrAddBalance(voter, util.SnapshotBalance{
+						Denom:   b.Denom,
+						Balance: amount,
+					})
+				}
+				records = append(records, splitRecord{
+					Multisig:    addr,
+					Denom:       b.Denom,
+					Held:        b.Balance,
+					Distributed: b.Balance.Sub(dust),
+					Dust:        dust,
+					Note:        note,
+				})
+			}
+			delete(snapshot, addr)
+		}
+		if !moved {
+			break
+		}
+	}
+	// whatever is left stays with the multisig, it is reported rather than split
+	for _, addr := range addresses {
+		for _, b := range multisigPosition(snapshot, addr) {
+			app.Logger().Error(fmt.Sprintf("multisig %s still holds %s %s after %d passes", addr, b.Balance, b.Denom, maxSplitPasses))
+			records = append(records, splitRecord{
+				Multisig:    addr,
+				Denom:       b.Denom,
+				Held:        b.Balance,
+				Distributed: sdk.ZeroInt(),
+				Dust:        sdk.ZeroInt(),
+				Note:        fmt.Sprintf("not split after %d passes", maxSplitPasses),
+			})
+		}
+	}
+	reportSplits(app, records)
 
-		// This is used to remove from the final snapshot since we already allocated it
-		contractBalanceMap[addr] = make(map[string]sdk.Int)
-		contractBalanceMap[addr][util.DenomUST] = ustBalance
-		contractBalanceMap[addr][util.DenomLUNA] = lunaBalance
-		contractBalanceMap[addr][util.DenomAUST] = aUSTBalance
+	mapKnownContracts(snapshot)
+	return nil
+}
 
-		// get total weight
-		var totalWeight int64
-		for _, voter := range voters {
-			totalWeight = totalWeight + int64(voter.Weight)
+// multisigPosition collapses the multisig's snapshot entry into one balance per denom
+func multisigPosition(snapshot util.SnapshotBalanceAggregateMap, addr string) []util.SnapshotBalance {
+	var holdings []util.SnapshotBalance
+	for _, b := range util.MergeSnapshots(util.SnapshotBalanceAggregateMap{addr: snapshot[addr]})[addr] {
+		if b.Balance.IsNil() || b.Balance.IsZero() {
+			continue
 		}
-		tw := sdk.NewDec(totalWeight)
+		holdings = append(holdings, b)
+	}
+	return holdings
+}
 
-		// split funds, append to final balance
-		for _, voter := range voters {
-			w := sdk.NewDec(int64(voter.Weight))
-			snapshot.AppendOrAddBalance(voter.Address, util.SnapshotBalance{
-				Denom:   util.DenomUST,
-				Balance: sdk.NewDecFromInt(ustBalance).Mul(w).Quo(tw).TruncateInt(),
-			})
-			snapshot.AppendOrAddBalance(voter.Address, util.SnapshotBalance{
-				Denom:   util.DenomLUNA,
-				Balance: sdk.NewDecFromInt(lunaBalance).Mul(w).Quo(tw).TruncateInt(),
-			})
-			snapshot.AppendOrAddBalance(voter.Address, util.SnapshotBalance{
-				Denom:   util.DenomAUST,
-				Balance: sdk.NewDecFromInt(aUSTBalance).Mul(w).Quo(tw).TruncateInt(),
-			})
+func reportSplits(app *terra.TerraApp, records []splitRecord) {
+	totalDust := make(map[string]sdk.Int)
+	var data [][]string
+	for _, r := range records {
+		if totalDust[r.Denom].IsNil() {
+			totalDust[r.Denom] = sdk.ZeroInt()
 		}
+		totalDust[r.Denom] = totalDust[r.Denom].Add(r.Dust)
+		data = append(data, []string{r.Multisig, r.Denom, r.Held.String(), r.Distributed.String(), r.Dust.String(), r.Note})
+	}
+	for denom, dust := range totalDust {
+		app.Logger().Info(fmt.Sprintf("... cw3 split dust %s %s", dust, denom))
 	}
 
-	// Subtract what has already been airdropped
-	for addr, balances := range contractBalanceMap {
-		for i, sbs := range snapshot[addr] {
-			if !balances[sbs.Denom].IsNil() {
-				remaining := sbs.Balance.Sub(balances[sbs.Denom])
-				if remaining.IsNegative() {
-					// panic(fmt.Errorf("negative balance %s, %s, %s", addr, sbs.Denom, remaining))
-					app.Logger().Info(fmt.Sprintf("negative balance %s, %s, %s", addr, sbs.Denom, remaining))
-				}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/cw3-splits.csv", folder), []string{"multisig", "denom", "held", "distributed", "dust", "note"}, data)
+}
 
-				snapshot[addr][i] = util.SnapshotBalance{
-					Denom:   sbs.Denom,
-					Balance: remaining,
-				}
-			}
+// getVoters returns the voters of a multisig with a non-zero weight
+func getVoters(ctx sdk.Context, keeper wasmkeeper.Keeper, initmsg Cw3InitMsg) ([]Voter, error) {
+	var voters []Voter
+	if len(initmsg.Voters) != 0 {
+		voters = initmsg.Voters
+	} else if initmsg.GroupAddr != "" {
+		members, err := getCw4Members(ctx, keeper, initmsg.GroupAddr)
+		if err != nil {
+			return nil, err
 		}
+		voters = members
 	}
-	mapKnownContracts(snapshot)
-	return nil
+
+	var weighted []Voter
+	for _, voter := range voters {
+		if voter.Weight > 0 {
+			weighted = append(weighted, voter)
+		}
+	}
+	return weighted, nil
+}
+
+// getCw4Members reads the current members of a cw4-group from the "members" map
+func getCw4Members(ctx sdk.Context, keeper wasmkeeper.Keeper, groupAddr string) ([]Voter, error) {
+	group, err := sdk.AccAddressFromBech32(groupAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Voter
+	prefix := util.GeneratePrefix("members")
+	keeper.IterateContractStateWithPrefix(ctx, group, prefix, func(key, value []byte) bool {
+		var weight int64
+		if err = json.Unmarshal(value, &weight); err != nil {
+			err = fmt.Errorf("unable to parse cw4 member weight of %s: %v", groupAddr, err)
+			return true
+		}
+
+		// older cw4-group versions key members by canonical address
+		var member string
+		if member, err = util.AddressFromKey(key); err != nil {
+			err = fmt.Errorf("unable to parse cw4 member of %s: %v", groupAddr, err)
+			return true
+		}
+		members = append(members, Voter{
+			Address: member,
+			Weight:  weight,
+		})
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// splitByWeight splits amount to voters pro-rata to their weight, truncating each share.
+// Returns the shares and the dust left over by truncation.
+func splitByWeight(amount sdk.Int, voters []Voter) (map[string]sdk.Int, sdk.Int) {
+	// get total weight
+	var totalWeight int64
+	for _, voter := range voters {
+		totalWeight = totalWeight + voter.Weight
+	}
+	tw := sdk.NewDec(totalWeight)
+
+	shares := make(map[string]sdk.Int)
+	dust := amount
+	for _, voter := range voters {
+		w := sdk.NewDec(voter.Weight)
+		share := sdk.NewDecFromInt(amount).Mul(w).Quo(tw).TruncateInt()
+		if shares[voter.Address].IsNil() {
+			shares[voter.Address] = share
+		} else {
+			shares[voter.Address] = shares[voter.Address].Add(share)
+		}
+		dust = dust.Sub(share)
+	}
+	return shares, dust
 }
 
 const contractMappingFile = "./app/export/generic/common/contract-mapping.csv"
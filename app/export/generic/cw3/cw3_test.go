@@ -0,0 +1,26 @@
+package cw3
+
+import (
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+func TestSplitByWeight(t *testing.T) {
+	voters := []Voter{
+		{Address: "addr1", Weight: 1},
+		{Address: "addr2", Weight: 1},
+		{Address: "addr3", Weight: 1},
+	}
+
+	shares, dust := splitByWeight(sdk.NewInt(100), voters)
+
+	for _, voter := range voters {
+		if !shares[voter.Address].Equal(sdk.NewInt(33)) {
+			t.Errorf("expected a share of 33 for %s, got %s", voter.Address, shares[voter.Address])
+		}
+	}
+	if !dust.Equal(sdk.NewInt(1)) {
+		t.Errorf("expected a dust of 1, got %s", dust)
+	}
+}
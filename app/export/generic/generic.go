@@ -35,7 +35,8 @@ func ExportVestingContracts(app *terra.TerraApp, bl util.Blacklist) (util.Snapsh
 }
 
 func HandleContractBalances(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap, contractsMap common.ContractsMap, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
-	// handle cw3, function directly updates the snapshot
+	// handle cw3, function directly updates the snapshot.
+	// snapshot must already have LP and LSD holdings resolved to the underlying.
 	snapshot = util.MergeSnapshots(make(util.SnapshotBalanceAggregateMap), snapshot)
 	if err := cw3.ExportCW3(app, contractsMap, snapshot, bl); err != nil {
 		panic(err)
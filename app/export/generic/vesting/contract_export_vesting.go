@@ -2,6 +2,11 @@ package vesting
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	terra "github.com/terra-money/core/app"
 	"github.com/terra-money/core/app/export/generic/common"
@@ -16,6 +21,7 @@ var (
 )
 
 type (
+	// vesting_info item of single-owner vesting contracts
 	SingleVestingInfo struct {
 		OwnerAddress sdk.AccAddress `json:"owner_address"`
 		VestingDenom struct {
@@ -32,46 +38,159 @@ type (
 		ClaimableAmount         sdk.Int `json:"claimable_amount"`
 		ClaimableStakingRewards sdk.Int `json:"claimable_staking_rewards"`
 	}
+
+	// vesting_info map entry of Astroport style vesting contracts
+	MultiVestingInfo struct {
+		Schedules      []VestingSchedule `json:"schedules"`
+		ReleasedAmount sdk.Int           `json:"released_amount"`
+	}
+
+	VestingSchedule struct {
+		StartPoint VestingSchedulePoint  `json:"start_point"`
+		EndPoint   *VestingSchedulePoint `json:"end_point,omitempty"`
+	}
+
+	VestingSchedulePoint struct {
+		Time   uint64  `json:"time"`
+		Amount sdk.Int `json:"amount"`
+	}
+
+	// vesting_info map entry of Anchor/Mirror style cw20 vesting contracts,
+	// schedules are (start time, end time, amount)
+	Cw20VestingInfo struct {
+		Schedules     [][3]json.RawMessage `json:"schedules"`
+		LastClaimTime uint64               `json:"last_claim_time"`
+	}
+
+	// allocations map entry of Mars style vesting contracts
+	MarsAllocation struct {
+		AllocatedAmount sdk.Int `json:"mars_allocated_amount"`
+		WithdrawnAmount sdk.Int `json:"mars_withdrawn_amount"`
+	}
+
+	multiVestingConfig struct {
+		// Astroport
+		TokenAddr    string `json:"token_addr"`
+		VestingToken *struct {
+			Token *struct {
+				ContractAddr string `json:"contract_addr"`
+			} `json:"token,omitempty"`
+			NativeToken *struct {
+				Denom string `json:"denom"`
+			} `json:"native_token,omitempty"`
+		} `json:"vesting_token,omitempty"`
+		// Anchor/Mirror
+		AnchorToken string  `json:"anchor_token"`
+		MirrorToken string  `json:"mirror_token"`
+		GenesisTime *uint64 `json:"genesis_time,omitempty"`
+		// Mars
+		AddressProvider string  `json:"address_provider_address"`
+		UnlockStartTime *uint64 `json:"unlock_start_time,omitempty"`
+	}
+
+	// vestingEntry is an amount still owed to a beneficiary by a vesting contract
+	vestingEntry struct {
+		Contract string
+		Owner    string
+		Asset    string
+		Amount   sdk.Int
+	}
+
+	vestingLayout int
 )
 
-// ExportVestingContracts look for ALL contracts that implements vesting_info key
-func ExportVestingContracts(app *terra.TerraApp, contractsMap common.ContractsMap, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+const (
+	layoutNone vestingLayout = iota
+	layoutAstroport
+	layoutCw20
+	layoutMars
+)
 
+// ExportVestingContracts look for ALL contracts that implements vesting_info key.
+// Single-owner contracts (vesting_info item) are found by querying every contract.
+// Multi-account contracts are found by code ID, from the config layout of one contract of
+// each code: Astroport (vesting_info map of schedules), Anchor/Mirror cw20 vesting
+// (vesting_info map of schedule tuples) and Mars (allocations map).
+// Entries vesting a non-target asset are written to vesting-non-target.csv,
+// contracts and entries that can not be decoded to vesting-skipped.csv.
+func ExportVestingContracts(app *terra.TerraApp, contractsMap common.ContractsMap, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+	app.Logger().Info("Exporting vesting contracts")
 	ctx := util.PrepCtx(app)
 	qs := util.PrepWasmQueryServer(app)
 
-	var finalBalance util.SnapshotBalanceAggregateMap
+	var contracts []string
+	for contractAddr := range contractsMap {
+		contracts = append(contracts, contractAddr)
+	}
+	sort.Strings(contracts)
 
-	for contractAddr, _ := range contractsMap {
-		vesting, isVesting := checkIfVesting(ctx, qs, app.WasmKeeper, contractAddr)
+	layouts := getMultiVestingLayouts(ctx, qs, contractsMap, contracts)
 
-		// skip if not vesting
-		if !isVesting {
+	var entries []vestingEntry
+	var skipped [][]string
+	for _, contractAddr := range contracts {
+		if vesting, isVesting := checkIfVesting(ctx, qs, contractAddr); isVesting {
+			entries = append(entries, handleSingleVesting(contractAddr, vesting)...)
 			continue
 		}
 
-		vestingResult := handleSingleVesting(vesting)
-		finalBalance = util.MergeSnapshots(finalBalance, vestingResult)
+		layout := layouts[contractsMap[contractAddr].CodeID]
+		if layout == layoutNone {
+			continue
+		}
+		multiEntries, err := handleMultiVesting(ctx, qs, app.WasmKeeper, contractAddr, layout)
+		if err != nil {
+			app.Logger().Error(fmt.Sprintf("skipping vesting contract %s: %v", contractAddr, err))
+			skipped = append(skipped, []string{contractAddr, err.Error()})
+			continue
+		}
+		entries = append(entries, multiEntries...)
 	}
 
+	finalBalance := make(util.SnapshotBalanceAggregateMap)
+	var nonTarget [][]string
+	for _, entry := range entries {
+		if entry.Amount.IsNil() || !entry.Amount.IsPositive() {
+			continue
+		}
+		denom, ok := coalesceToBalanceDenom(entry.Asset)
+		if !ok {
+			nonTarget = append(nonTarget, []string{entry.Contract, entry.Owner, entry.Asset, entry.Amount.String()})
+			continue
+		}
+		finalBalance.AppendOrAddBalance(entry.Owner, util.SnapshotBalance{
+			Denom:   denom,
+			Balance: entry.Amount,
+		})
+	}
+
+	app.Logger().Info(fmt.Sprintf("... %d vesting entries, %d with non-target assets, %d contracts skipped", len(entries), len(nonTarget), len(skipped)))
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/vesting-non-target.csv", folder), []string{"contract", "owner", "asset", "amount"}, nonTarget)
+	util.ToCsv(fmt.Sprintf("%s/vesting-skipped.csv", folder), []string{"contract", "reason"}, skipped)
+
 	return finalBalance, nil
 }
 
-func checkIfVesting(ctx context.Context, qs wasmtypes.QueryServer, keeper wasmkeeper.Keeper, contractAddr string) (*SingleVestingInfo, bool) {
+func checkIfVesting(ctx context.Context, qs wasmtypes.QueryServer, contractAddr string) (*SingleVestingInfo, bool) {
 	addr, _ := sdk.AccAddressFromBech32(contractAddr)
-	actx := sdk.UnwrapSDKContext(ctx)
 
-	var singleVesting = SingleVestingInfo{}
-	keeper.IterateContractStateWithPrefix(actx, addr, []byte(PrefixVestingInfo), func(_, _ []byte) bool {
-		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
-			ContractAddress: addr.String(),
-			QueryMsg:        []byte("{\"vesting_info\":{}}"),
-		}, &singleVesting); err != nil {
-			return true
-		}
-
-		return true
+	res, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+		ContractAddress: contractAddr,
+		Key:             []byte(PrefixVestingInfo),
 	})
+	if err != nil || len(res.Data) == 0 {
+		return nil, false
+	}
+
+	var singleVesting = SingleVestingInfo{}
+	if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: addr.String(),
+		QueryMsg:        []byte("{\"vesting_info\":{}}"),
+	}, &singleVesting); err != nil {
+		return nil, false
+	}
 
 	if !singleVesting.VestingAmount.IsNil() {
 		return &singleVesting, true
@@ -80,30 +199,238 @@ func checkIfVesting(ctx context.Context, qs wasmtypes.QueryServer, keeper wasmke
 	return nil, false
 }
 
-func handleSingleVesting(vestingInfo *SingleVestingInfo) util.SnapshotBalanceAggregateMap {
-	ownerAddress := vestingInfo.OwnerAddress
-	amount := vestingInfo.VestingAmount.Sub(vestingInfo.VestedAmount)
+// handleSingleVesting credits the owner with the unvested amount, the vested but unclaimed amount
+// and pending staking rewards
+func handleSingleVesting(contractAddr string, vestingInfo *SingleVestingInfo) []vestingEntry {
+	ownerAddress := vestingInfo.OwnerAddress.String()
 
-	var denom string
+	var asset string
 	if vestingInfo.VestingDenom.Native != "" {
-		denom = vestingInfo.VestingDenom.Native
+		asset = vestingInfo.VestingDenom.Native
 	} else {
-		denom = vestingInfo.VestingDenom.Cw20.String()
+		asset = vestingInfo.VestingDenom.Cw20.String()
 	}
 
-	utilDenom, ok := coalesceToBalanceDenom(denom)
-	if !ok {
-		return nil
+	amount := vestingInfo.VestingAmount
+	if !vestingInfo.VestedAmount.IsNil() {
+		amount = amount.Sub(vestingInfo.VestedAmount)
+	}
+	entries := []vestingEntry{
+		{Contract: contractAddr, Owner: ownerAddress, Asset: asset, Amount: amount},
+	}
+	if !vestingInfo.ClaimableAmount.IsNil() {
+		entries = append(entries, vestingEntry{Contract: contractAddr, Owner: ownerAddress, Asset: asset, Amount: vestingInfo.ClaimableAmount})
+	}
+	// staking rewards are paid in the staked vesting denom
+	if !vestingInfo.ClaimableStakingRewards.IsNil() {
+		entries = append(entries, vestingEntry{Contract: contractAddr, Owner: ownerAddress, Asset: asset, Amount: vestingInfo.ClaimableStakingRewards})
 	}
+	return entries
+}
+
+// getMultiVestingLayouts returns the layout of every code ID whose contracts are multi-account
+// vesting contracts, judged from the config of the first contract of the code
+func getMultiVestingLayouts(ctx context.Context, qs wasmtypes.QueryServer, contractsMap common.ContractsMap, contracts []string) map[uint64]vestingLayout {
+	layouts := make(map[uint64]vestingLayout)
+	probed := make(map[uint64]bool)
+	for _, contractAddr := range contracts {
+		codeID := contractsMap[contractAddr].CodeID
+		if probed[codeID] {
+			continue
+		}
+		probed[codeID] = true
 
-	return util.SnapshotBalanceAggregateMap{
-		ownerAddress.String(): {
-			{
-				Denom:   utilDenom,
-				Balance: amount,
-			},
-		},
+		config, err := getMultiVestingConfig(ctx, qs, contractAddr)
+		if err != nil {
+			continue
+		}
+		if layout := config.layout(); layout != layoutNone {
+			layouts[codeID] = layout
+		}
+	}
+	return layouts
+}
+
+func (config multiVestingConfig) layout() vestingLayout {
+	switch {
+	case config.TokenAddr != "" || config.VestingToken != nil:
+		return layoutAstroport
+	case (config.AnchorToken != "" || config.MirrorToken != "") && config.GenesisTime != nil:
+		return layoutCw20
+	case config.AddressProvider != "" && config.UnlockStartTime != nil:
+		return layoutMars
+	}
+	return layoutNone
+}
+
+// handleMultiVesting reads every account of a multi-account vesting contract from storage
+func handleMultiVesting(ctx context.Context, qs wasmtypes.QueryServer, keeper wasmkeeper.Keeper, contractAddr string, layout vestingLayout) ([]vestingEntry, error) {
+	addr, _ := sdk.AccAddressFromBech32(contractAddr)
+
+	config, err := getMultiVestingConfig(ctx, qs, contractAddr)
+	if err != nil {
+		return nil, err
+	}
+	asset, err := getMultiVestingAsset(ctx, qs, config, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := PrefixVestingInfoAsPrefix
+	if layout == layoutMars {
+		prefix = util.GeneratePrefix("allocations")
+	}
+
+	var entries []vestingEntry
+	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), addr, prefix, func(key, value []byte) bool {
+		var owner string
+		if owner, err = util.AddressFromKey(key); err != nil {
+			return true
+		}
+		var amount sdk.Int
+		if amount, err = decodeOwedAmount(value, layout); err != nil {
+			err = fmt.Errorf("unable to decode vesting of %s: %v", owner, err)
+			return true
+		}
+		entries = append(entries, vestingEntry{
+			Contract: contractAddr,
+			Owner:    owner,
+			Asset:    asset,
+			Amount:   amount,
+		})
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// decodeOwedAmount returns the amount a multi-account vesting contract still owes to an account,
+// vested or not
+func decodeOwedAmount(value []byte, layout vestingLayout) (sdk.Int, error) {
+	switch layout {
+	case layoutAstroport:
+		// sum of all schedules minus what was already released
+		var info MultiVestingInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return sdk.Int{}, err
+		}
+		total := sdk.ZeroInt()
+		for _, schedule := range info.Schedules {
+			if schedule.EndPoint != nil {
+				total = total.Add(schedule.EndPoint.Amount)
+			} else {
+				total = total.Add(schedule.StartPoint.Amount)
+			}
+		}
+		if !info.ReleasedAmount.IsNil() {
+			total = total.Sub(info.ReleasedAmount)
+		}
+		return total, nil
+
+	case layoutCw20:
+		// schedules release linearly from start to end, everything up to the last claim was paid out
+		var info Cw20VestingInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return sdk.Int{}, err
+		}
+		total := sdk.ZeroInt()
+		for _, schedule := range info.Schedules {
+			var start, end uint64
+			var amount sdk.Int
+			if err := json.Unmarshal(schedule[0], &start); err != nil {
+				return sdk.Int{}, err
+			}
+			if err := json.Unmarshal(schedule[1], &end); err != nil {
+				return sdk.Int{}, err
+			}
+			if err := json.Unmarshal(schedule[2], &amount); err != nil {
+				return sdk.Int{}, err
+			}
+			switch {
+			case info.LastClaimTime >= end:
+			case info.LastClaimTime <= start:
+				total = total.Add(amount)
+			default:
+				claimed := amount.MulRaw(int64(info.LastClaimTime - start)).QuoRaw(int64(end - start))
+				total = total.Add(amount.Sub(claimed))
+			}
+		}
+		return total, nil
+
+	case layoutMars:
+		var allocation MarsAllocation
+		if err := json.Unmarshal(value, &allocation); err != nil {
+			return sdk.Int{}, err
+		}
+		if allocation.AllocatedAmount.IsNil() {
+			return sdk.Int{}, fmt.Errorf("allocation without amount")
+		}
+		if allocation.WithdrawnAmount.IsNil() {
+			return allocation.AllocatedAmount, nil
+		}
+		return allocation.AllocatedAmount.Sub(allocation.WithdrawnAmount), nil
+	}
+	return sdk.Int{}, fmt.Errorf("unknown vesting layout %d", layout)
+}
+
+func getMultiVestingConfig(ctx context.Context, qs wasmtypes.QueryServer, contractAddr string) (multiVestingConfig, error) {
+	res, err := qs.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+		ContractAddress: contractAddr,
+		Key:             []byte("config"),
+	})
+	if err != nil {
+		return multiVestingConfig{}, err
+	}
+
+	var config multiVestingConfig
+	if err := json.Unmarshal(res.Data, &config); err != nil {
+		return multiVestingConfig{}, fmt.Errorf("unable to parse vesting config of %s: %v", contractAddr, err)
+	}
+	return config, nil
+}
+
+func getMultiVestingAsset(ctx context.Context, qs wasmtypes.QueryServer, config multiVestingConfig, layout vestingLayout) (string, error) {
+	switch {
+	case layout == layoutAstroport && config.TokenAddr != "":
+		return config.TokenAddr, nil
+	case layout == layoutAstroport && config.VestingToken != nil && config.VestingToken.Token != nil:
+		return config.VestingToken.Token.ContractAddr, nil
+	case layout == layoutAstroport && config.VestingToken != nil && config.VestingToken.NativeToken != nil:
+		return config.VestingToken.NativeToken.Denom, nil
+	case layout == layoutCw20 && config.AnchorToken != "":
+		return storedAddress(config.AnchorToken)
+	case layout == layoutCw20 && config.MirrorToken != "":
+		return storedAddress(config.MirrorToken)
+	case layout == layoutMars:
+		provider, err := storedAddress(config.AddressProvider)
+		if err != nil {
+			return "", err
+		}
+		// the MARS token is registered in the address provider
+		var token string
+		if err := util.ContractQuery(ctx, qs, &wasmtypes.QueryContractStoreRequest{
+			ContractAddress: provider,
+			QueryMsg:        []byte("{\"address\":{\"contract\":\"mars_token\"}}"),
+		}, &token); err != nil {
+			return "", fmt.Errorf("unable to query mars token: %v", err)
+		}
+		return token, nil
+	}
+	return "", fmt.Errorf("unknown vesting token")
+}
+
+// storedAddress decodes an address stored either as bech32 or as base64 canonical address
+func storedAddress(s string) (string, error) {
+	if _, err := sdk.AccAddressFromBech32(s); err == nil {
+		return s, nil
+	}
+	addr, err := util.AccAddressFromBase64(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid stored address %s: %v", s, err)
 	}
+	return addr.String(), nil
 }
 
 func coalesceToBalanceDenom(assetName string) (string, bool) {
@@ -112,6 +439,8 @@ func coalesceToBalanceDenom(assetName string) (string, bool) {
 		return util.DenomUST, true
 	case "uluna":
 		return util.DenomLUNA, true
+	case util.AddressAUST:
+		return util.DenomAUST, true
 	case util.AddressBLUNA:
 		return util.DenomBLUNA, true
 	case util.AddressSTLUNA:
@@ -3,12 +3,14 @@ package lido
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	// stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
 	terra "github.com/terra-money/core/app"
 	util "github.com/terra-money/core/app/export/util"
-	wasmkeeper "github.com/terra-money/core/x/wasm/keeper"
 	wasmtypes "github.com/terra-money/core/x/wasm/types"
 )
 
@@ -80,13 +82,14 @@ func ResolveLidoLuna(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregate
 			}
 		}
 	}
-	unbondingBluna, unbondingStLuna, err := getUnbondingTokens(ctx, app.WasmKeeper)
+	unbondingLuna, withdrawableLuna, err := getUnbondingLuna(app, ctx, q, lidoState)
 	if err != nil {
-		return nil
+		return err
 	}
-	unbondingLuna := util.MergeMaps(applyExchangeRates(unbondingBluna, lidoState.BLunaExchangeRate), applyExchangeRates(unbondingStLuna, lidoState.StLunaExchangeRate))
 	bl.RegisterAddress(util.DenomLUNA, LidoHub)
 	snapshot.Add(unbondingLuna, util.DenomLUNA)
+	// withdrawable LUNA sits in the hub until claimed
+	snapshot.Add(withdrawableLuna, util.DenomLUNA)
 	return nil
 }
 
@@ -234,39 +237,124 @@ func applyExchangeRates(balances map[string]sdk.Int, exchangeRate sdk.Dec) map[s
 	return lunaBalances
 }
 
-func getUnbondingTokens(ctx context.Context, k wasmkeeper.Keeper) (map[string]sdk.Int, map[string]sdk.Int, error) {
-	prefix := util.GeneratePrefix("v2_wait")
-	lidoHubAddr, err := sdk.AccAddressFromBech32(LidoHub)
+// getUnbondingLuna returns LUNA owed to users with unbonding requests.
+// Requests of the current batch use the current exchange rates, unreleased batches the batch's applied exchange rates.
+// Released batches use the batch's withdraw rates and are returned separately as withdrawable LUNA.
+func getUnbondingLuna(app *terra.TerraApp, ctx context.Context, q wasmtypes.QueryServer, lidoState LidoState) (map[string]sdk.Int, map[string]sdk.Int, error) {
+	history, err := getAllHistory(ctx, q)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	bLunaHolding := make(map[string]sdk.Int)
-	stLunaHolding := make(map[string]sdk.Int)
-	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), lidoHubAddr, prefix, func(key, value []byte) bool {
-		// key is in the format [len("\"address\"")]["address"][1 byte]
+
+	unbonding := make(map[string]sdk.Int)
+	withdrawable := make(map[string]sdk.Int)
+	credit := func(m map[string]sdk.Int, wallet string, amount sdk.Int) {
+		if amount.IsZero() {
+			return
+		}
+		if m[wallet].IsNil() {
+			m[wallet] = sdk.ZeroInt()
+		}
+		m[wallet] = m[wallet].Add(amount)
+	}
+
+	prefix := util.GeneratePrefix("v2_wait")
+	var iterErr error
+	app.WasmKeeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(LidoHub), prefix, func(key, value []byte) bool {
+		// key is in the format [len("\"address\"")]["address"][batch id]
 		var unbondingRes struct {
 			BLunaAmount  sdk.Int `json:"bluna_amount"`
 			StLunaAmount sdk.Int `json:"stluna_amount"`
 		}
 		wallet := string(key[3:47])
-		err = json.Unmarshal(value, &unbondingRes)
+		batchID, err := strconv.ParseUint(string(key[48:]), 10, 64)
 		if err != nil {
-			panic(err)
+			iterErr = fmt.Errorf("invalid batch id in unbonding request of %s: %v", wallet, err)
+			return true
 		}
-		// Users can have multiple unbounding requests
-		if !unbondingRes.BLunaAmount.IsZero() {
-			if bLunaHolding[wallet].IsNil() {
-				bLunaHolding[wallet] = sdk.NewInt(0)
-			}
-			bLunaHolding[wallet] = bLunaHolding[wallet].Add(unbondingRes.BLunaAmount)
+		if err = json.Unmarshal(value, &unbondingRes); err != nil {
+			iterErr = err
+			return true
 		}
-		if !unbondingRes.StLunaAmount.IsZero() {
-			if stLunaHolding[wallet].IsNil() {
-				stLunaHolding[wallet] = sdk.NewInt(0)
-			}
-			stLunaHolding[wallet] = stLunaHolding[wallet].Add(unbondingRes.StLunaAmount)
+		if unbondingRes.BLunaAmount.IsNil() {
+			unbondingRes.BLunaAmount = sdk.ZeroInt()
+		}
+		if unbondingRes.StLunaAmount.IsNil() {
+			unbondingRes.StLunaAmount = sdk.ZeroInt()
+		}
+
+		// Users can have multiple unbounding requests
+		batch, ok := history[batchID]
+		switch {
+		case !ok:
+			// current batch, not unbonded yet
+			credit(unbonding, wallet, lidoState.BLunaExchangeRate.MulInt(unbondingRes.BLunaAmount).TruncateInt())
+			credit(unbonding, wallet, lidoState.StLunaExchangeRate.MulInt(unbondingRes.StLunaAmount).TruncateInt())
+		case batch.Released:
+			credit(withdrawable, wallet, batch.BLunaWithdrawRate.MulInt(unbondingRes.BLunaAmount).TruncateInt())
+			credit(withdrawable, wallet, batch.StLunaWithdrawRate.MulInt(unbondingRes.StLunaAmount).TruncateInt())
+		default:
+			credit(unbonding, wallet, batch.BLunaAppliedExchangeRate.MulInt(unbondingRes.BLunaAmount).TruncateInt())
+			credit(unbonding, wallet, batch.StLunaAppliedExchangeRate.MulInt(unbondingRes.StLunaAmount).TruncateInt())
 		}
 		return false
 	})
-	return bLunaHolding, stLunaHolding, nil
+	if iterErr != nil {
+		return nil, nil, iterErr
+	}
+
+	app.Logger().Info(fmt.Sprintf("... %d batches, unbonding %s uluna, withdrawable %s uluna", len(history), util.Sum(unbonding), util.Sum(withdrawable)))
+
+	var data [][]string
+	for wallet, amount := range unbonding {
+		data = append(data, []string{wallet, "unbonding", amount.String()})
+	}
+	for wallet, amount := range withdrawable {
+		data = append(data, []string{wallet, "withdrawable", amount.String()})
+	}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/lido-unbonding.csv", folder), []string{"wallet", "status", "uluna"}, data)
+
+	return unbonding, withdrawable, nil
+}
+
+type unbondHistory struct {
+	BatchID                   uint64  `json:"batch_id"`
+	BLunaAppliedExchangeRate  sdk.Dec `json:"bluna_applied_exchange_rate"`
+	BLunaWithdrawRate         sdk.Dec `json:"bluna_withdraw_rate"`
+	StLunaAppliedExchangeRate sdk.Dec `json:"stluna_applied_exchange_rate"`
+	StLunaWithdrawRate        sdk.Dec `json:"stluna_withdraw_rate"`
+	Released                  bool    `json:"released"`
+}
+
+// getAllHistory returns every unbonding batch sent by the hub
+func getAllHistory(ctx context.Context, q wasmtypes.QueryServer) (map[uint64]unbondHistory, error) {
+	limit := 100
+	history := make(map[uint64]unbondHistory)
+	startFrom := uint64(0)
+	for {
+		var res struct {
+			History []unbondHistory `json:"history"`
+		}
+		err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+			ContractAddress: LidoHub,
+			QueryMsg:        []byte(fmt.Sprintf("{\"all_history\":{\"start_from\":%d,\"limit\":%d}}", startFrom, limit)),
+		}, &res)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range res.History {
+			history[h.BatchID] = h
+			startFrom = h.BatchID
+		}
+		if len(res.History) < limit {
+			return history, nil
+		}
+	}
+}
+
+// GetExchangeRates returns the lido hub state with the bLUNA and stLUNA exchange rates
+func GetExchangeRates(ctx context.Context, q wasmtypes.QueryServer) (LidoState, error) {
+	return getExchangeRates(ctx, q)
 }
@@ -0,0 +1,175 @@
+package mars
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	util "github.com/terra-money/core/app/export/util"
+	"github.com/terra-money/core/x/wasm/keeper"
+	wasmtypes "github.com/terra-money/core/x/wasm/types"
+)
+
+type userDebtResponse struct {
+	Debts []struct {
+		Denom  string  `json:"denom"`
+		Amount sdk.Int `json:"amount"`
+	} `json:"debts"`
+}
+
+// getRedbankDebts returns user => denom => debt for every borrower of the red bank.
+// Borrowers are found from the keys of the debts map, amounts come from the user_debt query.
+func getRedbankDebts(ctx context.Context, k keeper.Keeper, q wasmtypes.QueryServer) (map[string]map[string]sdk.Int, error) {
+	// debts are keyed by (asset reference, user)
+	borrowers := make(map[string]bool)
+	var err error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(marsMarket), util.GeneratePrefix("debts"), func(key, value []byte) bool {
+		var parts [][]byte
+		if parts, err = util.SplitKey(key, 2); err != nil {
+			return true
+		}
+		var borrower string
+		if borrower, err = util.AddressFromKey(parts[1]); err != nil {
+			return true
+		}
+		borrowers[borrower] = true
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode red bank debts: %v", err)
+	}
+
+	debts := make(map[string]map[string]sdk.Int)
+	for borrower := range borrowers {
+		debt, err := getUserDebt(ctx, q, borrower)
+		if err != nil {
+			return nil, err
+		}
+		if len(debt) > 0 {
+			debts[borrower] = debt
+		}
+	}
+	return debts, nil
+}
+
+func getUserDebt(ctx context.Context, q wasmtypes.QueryServer, user string) (map[string]sdk.Int, error) {
+	var res userDebtResponse
+	err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: marsMarket,
+		QueryMsg:        []byte(fmt.Sprintf("{\"user_debt\":{\"user_address\":\"%s\"}}", user)),
+	}, &res)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query mars debt of %s: %v", user, err)
+	}
+	debt := make(map[string]sdk.Int)
+	for _, d := range res.Debts {
+		if d.Denom != util.DenomUST && d.Denom != util.DenomLUNA {
+			return nil, fmt.Errorf("mars: unexpected debt in %s for %s", d.Denom, user)
+		}
+		if !d.Amount.IsNil() && d.Amount.IsPositive() {
+			debt[d.Denom] = d.Amount
+		}
+	}
+	return debt, nil
+}
+
+// netRedbankDebts subtracts the debt of every borrower from their claims in place.
+// Debt is netted in the same asset first, the rest against the other asset at its uusd price;
+// collateral taken that way is given to depositors of the borrowed asset. Field of Mars contracts
+// are skipped, their debt is repaid from their LP. Returns the uusd value of uncovered debt.
+func netRedbankDebts(
+	claims map[string]map[string]sdk.Int,
+	debts map[string]map[string]sdk.Int,
+	prices map[string]sdk.Dec,
+	folder string,
+) sdk.Dec {
+	other := map[string]string{
+		util.DenomUST:  util.DenomLUNA,
+		util.DenomLUNA: util.DenomUST,
+	}
+	fields := make(map[string]bool)
+	for _, field := range marsFields {
+		fields[field] = true
+	}
+
+	var borrowers []string
+	for borrower := range debts {
+		borrowers = append(borrowers, borrower)
+	}
+	sort.Strings(borrowers)
+
+	badDebt := sdk.ZeroDec()
+	taken := map[string]sdk.Int{
+		util.DenomUST:  sdk.ZeroInt(),
+		util.DenomLUNA: sdk.ZeroInt(),
+	}
+	var rows [][]string
+	for _, borrower := range borrowers {
+		if fields[borrower] {
+			continue
+		}
+		for _, denom := range []string{util.DenomUST, util.DenomLUNA} {
+			amount, ok := debts[borrower][denom]
+			if !ok {
+				continue
+			}
+			claim := orZero(claims[denom][borrower])
+			netted := sdk.MinInt(claim, amount)
+			claims[denom][borrower] = claim.Sub(netted)
+			left := amount.Sub(netted)
+
+			o := other[denom]
+			value := prices[denom].MulInt(left)
+			collateral := orZero(claims[o][borrower])
+			take := collateral
+			if prices[o].MulInt(collateral).GT(value) {
+				take = sdk.MinInt(collateral, value.Quo(prices[o]).Ceil().TruncateInt())
+				value = sdk.ZeroDec()
+			} else {
+				value = value.Sub(prices[o].MulInt(collateral))
+			}
+			if take.IsPositive() {
+				claims[o][borrower] = collateral.Sub(take)
+				taken[o] = taken[o].Add(take)
+			}
+			badDebt = badDebt.Add(value)
+			rows = append(rows, []string{borrower, denom, amount.String(), netted.String(), o, take.String(), value.TruncateInt().String()})
+		}
+	}
+	util.ToCsv(fmt.Sprintf("%s/mars-borrowers.csv", folder), []string{"address", "denom", "debt", "netted", "collateral_denom", "collateral_taken", "uncovered_uusd"}, rows)
+
+	// collateral taken for debt in denom belongs to depositors of denom
+	for from, amount := range taken {
+		if amount.IsZero() {
+			continue
+		}
+		to := other[from]
+		total := util.Sum(claims[to])
+		if total.IsZero() {
+			continue
+		}
+		for depositor, claim := range claims[to] {
+			claims[from][depositor] = orZero(claims[from][depositor]).Add(claim.Mul(amount).Quo(total))
+		}
+	}
+	return badDebt
+}
+
+// scaleClaims scales claims pro-rata so they add up to the bank balance
+func scaleClaims(claims map[string]sdk.Int, bank sdk.Int) {
+	total := util.Sum(claims)
+	if total.IsZero() {
+		return
+	}
+	for addr, claim := range claims {
+		claims[addr] = claim.Mul(bank).Quo(total)
+	}
+}
+
+func orZero(i sdk.Int) sdk.Int {
+	if i.IsNil() {
+		return sdk.ZeroInt()
+	}
+	return i
+}
@@ -4,19 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/tendermint/tendermint/libs/log"
 	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/dex"
 	util "github.com/terra-money/core/app/export/util"
 	"github.com/terra-money/core/x/wasm/keeper"
 	wasmtypes "github.com/terra-money/core/x/wasm/types"
 )
 
 var (
-	marsMarket  = "terra19dtgj9j5j7kyf3pmejqv8vzfpxtejaypgzkz5u"
-	maLunaToken = "terra1x4rrkxx5pyuce32wsdn8ypqnpx8n27klnegv0d"
-	maUstToken  = "terra1cuku0vggplpgfxegdrenp302km26symjk4xxaf"
-	// TODO: assign safety fund to mars multisig
+	marsMarket     = "terra19dtgj9j5j7kyf3pmejqv8vzfpxtejaypgzkz5u"
+	maLunaToken    = "terra1x4rrkxx5pyuce32wsdn8ypqnpx8n27klnegv0d"
+	maUstToken     = "terra1cuku0vggplpgfxegdrenp302km26symjk4xxaf"
 	marsSafetyFund = "terra16zrcxq6pyq7uxhcmgfe68p09xh6g4wk6yw2f70"
 	marsFields     = []string{
 		//marsLunaUstField
@@ -30,31 +32,87 @@ var (
 	marsLockDrop       = "terra1n38982txtv2yygtcfv3e9wp2ktmjyxl6z88rma"
 	marsAuction        = "terra1hgyamk2kcy3stqx82wrnsklw9aq7rask5dxfds"
 	marsUstLp          = "terra1ww6sqvfgmktp0afcmvg78st6z89x5zr3tmvpss"
+
+	// SafetyFundBeneficiary receives the safety fund, the safety fund admin when empty.
+	// Set with the mars-safety-fund-beneficiary flag.
+	SafetyFundBeneficiary = ""
 )
 
 // To prevent double counting, snapshot only assign depositors what is left in the 'bank'
-// borrowers are eligible for the snapshot
 // Logic:
 // 1. Find ownership of maTokens
-// 2. Find total supply of maTokens
-// 3. Find balance of assets in bank
-// 4. Assign accounts with assets proportionally
+// 2. Value maTokens at bank balance plus outstanding debt
+// 3. Net borrowers' debt against their deposits, see netRedbankDebts
+// 4. Scale claims down to the balance of assets in bank, uncovered debt is a haircut on depositors
 func ExportContract(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
 	app.Logger().Info("Exporting MARS")
-	lunaSs, err := ExportMarsDepositLuna(app, bl)
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+
+	lunaPrice, err := app.OracleKeeper.GetLunaExchangeRate(sdk.UnwrapSDKContext(ctx), util.DenomUST)
 	if err != nil {
 		return nil, err
 	}
-	ustSs, err := ExportMarsDepositUST(app, bl)
+	prices := map[string]sdk.Dec{
+		util.DenomUST:  sdk.OneDec(),
+		util.DenomLUNA: lunaPrice,
+	}
+
+	debts, err := getRedbankDebts(ctx, app.WasmKeeper, q)
 	if err != nil {
 		return nil, err
 	}
+	app.Logger().Info(fmt.Sprintf("... %d mars borrowers", len(debts)))
+
+	claims := make(map[string]map[string]sdk.Int)
+	banks := make(map[string]sdk.Int)
+	for denom, token := range map[string]string{util.DenomLUNA: maLunaToken, util.DenomUST: maUstToken} {
+		shares, totalSupply, err := getDepositShares(app, token)
+		if err != nil {
+			return nil, err
+		}
+		banks[denom], err = util.GetNativeBalance(ctx, app.BankKeeper, denom, marsMarket)
+		if err != nil {
+			return nil, err
+		}
+		totalDebt := sdk.ZeroInt()
+		for _, debt := range debts {
+			totalDebt = totalDebt.Add(orZero(debt[denom]))
+		}
+		liquidity := banks[denom].Add(totalDebt)
+		claims[denom] = make(map[string]sdk.Int)
+		for addr, share := range shares {
+			if !share.IsZero() {
+				claims[denom][addr] = share.Mul(liquidity).Quo(totalSupply)
+			}
+		}
+	}
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	badDebt := netRedbankDebts(claims, debts, prices, folder)
+	if badDebt.IsPositive() {
+		app.Logger().Info(fmt.Sprintf("... mars debt worth %s uusd not covered by deposits", badDebt.TruncateInt()))
+	}
+
+	snapshot := make(util.SnapshotBalanceAggregateMap)
+	for denom, claim := range claims {
+		scaleClaims(claim, banks[denom])
+		for addr, amount := range claim {
+			if amount.IsZero() {
+				delete(claim, addr)
+			}
+		}
+		// Black listing Mars Market Contract for deduplication later
+		bl.RegisterAddress(denom, marsMarket)
+		snapshot.Add(claim, denom)
+	}
+
 	safetySs, err := ExportMarsSafetyFund(app, bl)
 	if err != nil {
 		return nil, err
 	}
-	marsSs := util.MergeSnapshots(lunaSs, ustSs, safetySs)
-	return marsSs, nil
+	return util.MergeSnapshots(snapshot, safetySs), nil
 }
 
 func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error {
@@ -87,67 +145,34 @@ func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error
 	return nil
 }
 
-func ExportMarsDepositLuna(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+// getDepositShares returns maToken balances and total supply, with maUST locked in the lockdrop
+// resolved to its users
+func getDepositShares(app *terra.TerraApp, token string) (map[string]sdk.Int, sdk.Int, error) {
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
-	logger := app.Logger()
-
-	var balances = make(map[string]sdk.Int)
-	logger.Info("... fetching MARS liquidity (LUNA)...")
-
-	if err := util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, maLunaToken, balances); err != nil {
-		return nil, err
-	}
-
-	marsLunaBalance, err := util.GetNativeBalance(ctx, app.BankKeeper, util.DenomLUNA, marsMarket)
-	if err != nil {
-		return nil, err
-	}
-	totalSupply, err := util.GetCW20TotalSupply(ctx, q, maLunaToken)
-	if err != nil {
-		return nil, err
-	}
-
-	sum := sdk.NewInt(0)
-	for address, balance := range balances {
-		if balance.IsZero() {
-			continue
-		}
-		balances[address] = balance.Mul(marsLunaBalance).Quo(totalSupply)
-		sum = sum.Add(balances[address])
-	}
-
-	snapshot := make(util.SnapshotBalanceAggregateMap)
-	// Black listing Mars Market Contract for deduplication later
-	bl.RegisterAddress(util.DenomLUNA, marsMarket)
-	snapshot.Add(balances, util.DenomLUNA)
-	return snapshot, nil
-}
-
-func ExportMarsDepositUST(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
-	ctx := util.PrepCtx(app)
-	q := util.PrepWasmQueryServer(app)
-	logger := app.Logger()
-
-	lockedHolders, _ := getLockedMaUst(ctx, app.WasmKeeper)
 
 	var balances = make(util.BalanceMap)
-	logger.Info("fetching MARS liquidity (UST)...")
-
-	if err := util.GetCW20AccountsAndBalances(ctx, app.WasmKeeper, maUstToken, balances); err != nil {
-		return nil, err
+	if token == maUstToken {
+		app.Logger().Info("... fetching MARS liquidity (UST)...")
+		if err := util.GetCW20AccountsAndBalances(ctx, app.WasmKeeper, token, balances); err != nil {
+			return nil, sdk.Int{}, err
+		}
+	} else {
+		app.Logger().Info("... fetching MARS liquidity (LUNA)...")
+		if err := util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, token, balances); err != nil {
+			return nil, sdk.Int{}, err
+		}
 	}
-
-	marsUstBalance, err := util.GetNativeBalance(ctx, app.BankKeeper, util.DenomUST, marsMarket)
+	totalSupply, err := util.GetCW20TotalSupply(ctx, q, token)
 	if err != nil {
-		return nil, err
+		return nil, sdk.Int{}, err
 	}
-	totalSupply, err := util.GetCW20TotalSupply(ctx, q, maUstToken)
-	if err != nil {
-		return nil, err
+	if token != maUstToken {
+		return balances, totalSupply, nil
 	}
 
 	// Resolve UST holders that were in the lock drop
+	lockedHolders, _ := getLockedMaUst(ctx, app.WasmKeeper)
 	totalShares := util.Sum(lockedHolders)
 	rate := sdk.NewDecFromInt(balances[marsLockDrop]).QuoInt(totalShares)
 	for addr, balance := range lockedHolders {
@@ -161,24 +186,9 @@ func ExportMarsDepositUST(app *terra.TerraApp, bl util.Blacklist) (util.Snapshot
 
 	err = util.AlmostEqual("mars aUST", util.Sum(balances), totalSupply, sdk.NewInt(10000))
 	if err != nil {
-		panic(err)
-	}
-
-	// Split the remaining funds in the bank
-	sum := sdk.NewInt(0)
-	for address, balance := range balances {
-		if balance.IsZero() {
-			continue
-		}
-		balances[address] = balance.Mul(marsUstBalance).Quo(totalSupply)
-		sum = sum.Add(balances[address])
+		return nil, sdk.Int{}, err
 	}
-
-	snapshot := make(util.SnapshotBalanceAggregateMap)
-	// Black listing Mars Market Contract for deduplication later
-	bl.RegisterAddress(util.DenomUST, marsMarket)
-	snapshot.Add(balances, util.DenomUST)
-	return snapshot, nil
+	return balances, totalSupply, nil
 }
 
 func getLockedMaUst(ctx context.Context, k keeper.Keeper) (map[string]sdk.Int, error) {
@@ -205,12 +215,17 @@ func ExportMarsSafetyFund(app *terra.TerraApp, bl util.Blacklist) (util.Snapshot
 	if err != nil {
 		return nil, err
 	}
-	info, err := app.WasmKeeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), util.ToAddress(marsSafetyFund))
-	if err != nil {
-		return nil, err
+	beneficiary := SafetyFundBeneficiary
+	if beneficiary == "" {
+		info, err := app.WasmKeeper.GetContractInfo(sdk.UnwrapSDKContext(ctx), util.ToAddress(marsSafetyFund))
+		if err != nil {
+			return nil, err
+		}
+		beneficiary = info.Admin
 	}
+	app.Logger().Info(fmt.Sprintf("... safety fund %s uusd to %s", balance, beneficiary))
 	snapshot := make(util.SnapshotBalanceAggregateMap)
-	snapshot[info.Admin] = append(snapshot[info.Admin], util.SnapshotBalance{
+	snapshot[beneficiary] = append(snapshot[beneficiary], util.SnapshotBalance{
 		Denom:   util.DenomUST,
 		Balance: balance,
 	})
@@ -223,15 +238,20 @@ func ExportMarsSafetyFund(app *terra.TerraApp, bl util.Blacklist) (util.Snapshot
 // 2. List all positions recurrsively
 // 3. Find how much LP tokens are deposited at the astroport generator
 // 4. Split the LP based on bond_unit and create a holding map with format {farm: {"lp_token_addr": {"wallet_addr": "amount"}}}
+// 5. Subtract each position's share of the field's red bank debt in LP, repaid LP goes to maUST holders
 func ExportFieldOfMarsLpTokens(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) (map[string]map[string]map[string]sdk.Int, error) {
 	app.Logger().Info("Exporting Field of Mars")
 	q := util.PrepWasmQueryServer(app)
 	ctx := util.PrepCtx(app)
+	maUstShares, _, err := getDepositShares(app, maUstToken)
+	if err != nil {
+		return nil, err
+	}
 	holdings := make(map[string]map[string]map[string]sdk.Int)
 	lpTokenFieldMap := make(map[string]string)
 	for _, fieldContract := range marsFields {
 		holding := make(map[string]map[string]sdk.Int)
-		err := getFieldOfMarsPositions(ctx, q, fieldContract, holding, lpTokenFieldMap)
+		err := getFieldOfMarsPositions(app.Logger(), ctx, q, fieldContract, holding, lpTokenFieldMap, maUstShares)
 		holdings[fieldContract] = holding
 		if err != nil {
 			app.Logger().Error(err.Error())
@@ -321,14 +341,17 @@ func getAstroportGeneratorDeposit(ctx context.Context, q wasmtypes.QueryServer,
 }
 
 func getFieldOfMarsPositions(
+	logger log.Logger,
 	ctx context.Context,
 	q wasmtypes.QueryServer,
 	fieldContract string,
 	holdings map[string]map[string]sdk.Int,
 	lpTokenFieldMap map[string]string,
+	maUstShares map[string]sdk.Int,
 ) error {
 	var fieldConfig struct {
 		PrimaryPair struct {
+			ContractAddr   string `json:"contract_addr"`
 			LiquidityToken string `json:"liquidity_token"`
 		} `json:"primary_pair"`
 	}
@@ -343,6 +366,7 @@ func getFieldOfMarsPositions(
 
 	var fieldState struct {
 		TotalBondUnits sdk.Int `json:"total_bond_units"`
+		TotalDebtUnits sdk.Int `json:"total_debt_units"`
 	}
 	err = util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
 		ContractAddress: fieldContract,
@@ -361,6 +385,7 @@ func getFieldOfMarsPositions(
 		User     string `json:"user"`
 		Position struct {
 			BondUnits sdk.Int `json:"bond_units"`
+			DebtUnits sdk.Int `json:"debt_units"`
 		} `json:"position"`
 	}
 
@@ -391,9 +416,55 @@ func getFieldOfMarsPositions(
 	}
 	// fmt.Printf("number of positions: %d\n", len(positions))
 
+	// LP needed to repay one uusd of debt, valuing LP at twice its UST side
+	debts, err := getUserDebt(ctx, q, fieldContract)
+	if err != nil {
+		return err
+	}
+	fieldDebt := orZero(debts[util.DenomUST])
+	var pool dex.Pool
+	err = util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: fieldConfig.PrimaryPair.ContractAddr,
+		QueryMsg:        []byte("{\"pool\":{}}"),
+	}, &pool)
+	if err != nil {
+		return err
+	}
+	ustReserve := sdk.ZeroInt()
+	for _, asset := range pool.Assets {
+		if dex.PickDenomOrContractAddress(asset.AssetInfo) == util.DenomUST {
+			ustReserve = asset.Amount
+		}
+	}
+	if fieldDebt.IsPositive() && ustReserve.IsZero() {
+		return fmt.Errorf("mars field %s: no UST side to value %s debt", fieldContract, fieldDebt)
+	}
+
 	lpHoldings := make(map[string]sdk.Int)
+	repaidLp := sdk.ZeroInt()
+	uncoveredLp := sdk.ZeroInt()
 	for _, pos := range positions {
-		lpHoldings[pos.User] = pos.Position.BondUnits.Mul(astroportGeneratorBalance).Quo(fieldState.TotalBondUnits)
+		lp := pos.Position.BondUnits.Mul(astroportGeneratorBalance).Quo(fieldState.TotalBondUnits)
+		debtLp := sdk.ZeroInt()
+		if !orZero(pos.Position.DebtUnits).IsZero() && !fieldState.TotalDebtUnits.IsZero() {
+			debt := pos.Position.DebtUnits.Mul(fieldDebt).Quo(fieldState.TotalDebtUnits)
+			debtLp = debt.Mul(pool.TotalShare).Quo(ustReserve.MulRaw(2))
+		}
+		repaid := sdk.MinInt(lp, debtLp)
+		lpHoldings[pos.User] = lp.Sub(repaid)
+		repaidLp = repaidLp.Add(repaid)
+		uncoveredLp = uncoveredLp.Add(debtLp.Sub(repaid))
+	}
+	if uncoveredLp.IsPositive() {
+		logger.Info(fmt.Sprintf("... mars field %s: debt worth %s LP not covered by positions", fieldContract, uncoveredLp))
+	}
+
+	// LP repaying the debt belongs to the red bank depositors
+	totalShares := util.Sum(maUstShares)
+	if repaidLp.IsPositive() && totalShares.IsPositive() {
+		for addr, share := range maUstShares {
+			lpHoldings[addr] = orZero(lpHoldings[addr]).Add(share.Mul(repaidLp).Quo(totalShares))
+		}
 	}
 	holdings[fieldConfig.PrimaryPair.LiquidityToken] = lpHoldings
 	return nil
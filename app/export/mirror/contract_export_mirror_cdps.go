@@ -3,10 +3,12 @@ package mirror
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/anchor"
 	util "github.com/terra-money/core/app/export/util"
 	"github.com/terra-money/core/x/wasm/types"
 )
@@ -24,6 +26,24 @@ var (
 	addressLunaX = "terra17y9qkl8dfkeg4py7n0g5407emqnemc3yqk5rup"
 )
 
+// positionReport is a row of the per-position CSV
+type positionReport struct {
+	Idx             sdk.Int
+	Owner           string
+	IsShort         bool
+	CollateralDenom string
+	Collateral      sdk.Int
+	MintedAsset     string
+	Minted          sdk.Int
+	AssetPrice      sdk.Dec
+	Debt            sdk.Dec // in uusd
+	CollateralPrice sdk.Dec // in uusd
+	NetCollateral   sdk.Int
+	LockedUST       sdk.Int
+}
+
+// ExportMirrorCdps credits each CDP owner its collateral net of the minted debt valued at oracle price.
+// UST from short sales locked in the lock contract is credited to the position owner.
 func ExportMirrorCdps(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
 	app.Logger().Info("Exporting Mirror CDPs")
 	ctx := util.PrepCtx(app)
@@ -34,53 +54,117 @@ func ExportMirrorCdps(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBala
 		return nil, err
 	}
 
-	//fmt.Printf("mirror CPD count %d\n", len(positions))
-
 	// get LunaX exchange rate
 	lunaXExchangeRate, err := getLunaXExchangeRate(ctx, q)
 	if err != nil {
 		return nil, err
 	}
-	// fmt.Printf("got LunaX exchange rate %s\n", lunaXExchangeRate)
+
+	collateralPrices, err := getCollateralPrices(app, lunaXExchangeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Oracle string `json:"oracle"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: MirrorMint,
+		QueryMsg:        []byte("{\"config\":{}}"),
+	}, &config); err != nil {
+		return nil, err
+	}
+	assetPrices := make(map[string]sdk.Dec)
 
 	snapshot := make(util.SnapshotBalanceAggregateMap)
+	var reports []positionReport
 
 	for _, position := range positions {
-		for _, denom := range MirrorRelevantCollaterals {
-			if position.Collateral.Info.Token.Addr == denom || position.Collateral.Info.NativeToken.Denom == denom {
-				if position.Collateral.Info.Token.Addr == addressLunaX {
-					// resolve lunaX
-					lunaAmount := lunaXExchangeRate.MulInt(position.Collateral.Amount).TruncateInt()
-
-					snapshot.AppendOrAddBalance(position.Owner, util.SnapshotBalance{Denom: util.DenomLUNA, Balance: lunaAmount})
-				} else {
-					// normal case (uluna, uusd, or AUST)
-					snapshot.AppendOrAddBalance(position.Owner, util.SnapshotBalance{Denom: util.MapContractToDenom(denom), Balance: position.Collateral.Amount})
-				}
+		var denom string
+		for _, d := range MirrorRelevantCollaterals {
+			if position.Collateral.Info.Token.Addr == d || position.Collateral.Info.NativeToken.Denom == d {
+				denom = d
 				break
 			}
 		}
+		if denom == "" {
+			continue
+		}
+
+		mAsset := position.Asset.Info.Token.Addr
+		if _, ok := assetPrices[mAsset]; !ok {
+			price, err := getAssetPrice(ctx, q, config.Oracle, mAsset)
+			if err != nil {
+				return nil, err
+			}
+			assetPrices[mAsset] = price
+		}
+
+		report := positionReport{
+			Idx:             position.Idx,
+			Owner:           position.Owner,
+			IsShort:         position.IsShort,
+			CollateralDenom: denom,
+			Collateral:      position.Collateral.Amount,
+			MintedAsset:     mAsset,
+			Minted:          position.Asset.Amount,
+			AssetPrice:      assetPrices[mAsset],
+			CollateralPrice: collateralPrices[denom],
+			LockedUST:       sdk.ZeroInt(),
+		}
+		report.Debt = report.AssetPrice.MulInt(report.Minted)
+
+		// debt expressed in collateral
+		if report.CollateralPrice.IsNil() || !report.CollateralPrice.IsPositive() {
+			return nil, fmt.Errorf("mirror position %s: no price for collateral %s", position.Idx, denom)
+		}
+		debtInCollateral := report.Debt.Quo(report.CollateralPrice).Ceil().TruncateInt()
+		report.NetCollateral = report.Collateral.Sub(debtInCollateral)
+		if report.NetCollateral.IsNegative() {
+			report.NetCollateral = sdk.ZeroInt()
+		}
+
+		if denom == addressLunaX {
+			// resolve lunaX
+			lunaAmount := lunaXExchangeRate.MulInt(report.NetCollateral).TruncateInt()
+			snapshot.AppendOrAddBalance(position.Owner, util.SnapshotBalance{Denom: util.DenomLUNA, Balance: lunaAmount})
+		} else {
+			// normal case (uluna, uusd, or AUST)
+			snapshot.AppendOrAddBalance(position.Owner, util.SnapshotBalance{Denom: util.MapContractToDenom(denom), Balance: report.NetCollateral})
+		}
+
+		if position.IsShort {
+			locked, err := getLockedAmount(ctx, q, position.Idx)
+			if err != nil {
+				return nil, err
+			}
+			report.LockedUST = locked
+			snapshot.AppendOrAddBalance(position.Owner, util.SnapshotBalance{Denom: util.DenomUST, Balance: locked})
+		}
+
+		reports = append(reports, report)
 	}
 
+	reportPositions(app, reports)
+
 	// blacklist mint contract
 	for _, denom := range MirrorRelevantCollaterals {
 		bl.RegisterAddress(denom, MirrorMint)
 	}
 	bl.RegisterAddress(util.DenomAUST, MirrorMint)
-	// also blacklist mirror lock to prevent double incenvitizing users shorting
+	// locked UST is credited to position owners
 	bl.RegisterAddress(util.DenomUST, MirrorLock)
 
 	return snapshot, nil
 }
 
+// AuditCdps checks the gross collateral of all positions against the mint's balances,
+// and that owners are not credited more than the gross collateral and locked UST.
 func AuditCdps(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error {
 	app.Logger().Info("Audit -- Mirror")
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
 
-	// calculate total lunax amount
-	totalLunaXAmount := sdk.ZeroInt()
-
 	// get LunaX exchange rate
 	lunaXExchangeRate, err := getLunaXExchangeRate(ctx, q)
 	if err != nil {
@@ -92,9 +176,16 @@ func AuditCdps(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) e
 		return err
 	}
 
+	grossCollateral := make(map[string]sdk.Int)
+	for _, denom := range MirrorRelevantCollaterals {
+		grossCollateral[denom] = sdk.ZeroInt()
+	}
 	for _, position := range positions {
-		if position.Collateral.Info.Token.Addr == addressLunaX {
-			totalLunaXAmount = totalLunaXAmount.Add(position.Collateral.Amount)
+		for _, denom := range MirrorRelevantCollaterals {
+			if position.Collateral.Info.Token.Addr == denom || position.Collateral.Info.NativeToken.Denom == denom {
+				grossCollateral[denom] = grossCollateral[denom].Add(position.Collateral.Amount)
+				break
+			}
 		}
 	}
 
@@ -109,28 +200,127 @@ func AuditCdps(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) e
 		if err != nil {
 			return err
 		}
-
-		if denom == addressLunaX {
-			// compare with recorded total
-			err = util.AlmostEqual(denom, contractBalance, totalLunaXAmount, sdk.NewInt(1000000))
-		} else if denom == util.DenomLUNA {
-			// need to include the converted lunaX
-			lunaXValue := lunaXExchangeRate.MulInt(totalLunaXAmount).TruncateInt()
-			expectedLunaBalance := snapshot.SumOfDenom(denom).Sub(lunaXValue)
-			err = util.AlmostEqual(denom, contractBalance, expectedLunaBalance, sdk.NewInt(1000000))
-		} else {
-			sumOfSnapshot := snapshot.SumOfDenom(util.MapContractToDenom(denom))
-			err = util.AlmostEqual(denom, contractBalance, sumOfSnapshot, sdk.NewInt(1000000))
+		if err = util.AlmostEqual(denom, contractBalance, grossCollateral[denom], sdk.NewInt(1000000)); err != nil {
+			return err
 		}
+	}
 
-		if err != nil {
-			return err
+	maxCredited := map[string]sdk.Int{
+		util.DenomLUNA: grossCollateral[util.DenomLUNA].Add(lunaXExchangeRate.MulInt(grossCollateral[addressLunaX]).TruncateInt()),
+		util.DenomAUST: grossCollateral[util.AUST],
+	}
+	lockedUST, err := util.GetNativeBalance(ctx, app.BankKeeper, util.DenomUST, MirrorLock)
+	if err != nil {
+		return err
+	}
+	maxCredited[util.DenomUST] = grossCollateral[util.DenomUST].Add(lockedUST)
+
+	for denom, limit := range maxCredited {
+		if credited := snapshot.SumOfDenom(denom); credited.GT(limit.Add(sdk.NewInt(1000000))) {
+			return fmt.Errorf("mirror credited %s %s, more than collateral %s", credited, denom, limit)
 		}
 	}
 
 	return nil
 }
 
+func reportPositions(app *terra.TerraApp, reports []positionReport) {
+	var data [][]string
+	for _, r := range reports {
+		data = append(data, []string{
+			r.Idx.String(), r.Owner, fmt.Sprintf("%t", r.IsShort),
+			r.CollateralDenom, r.Collateral.String(), r.CollateralPrice.String(),
+			r.MintedAsset, r.Minted.String(), r.AssetPrice.String(), r.Debt.String(),
+			r.NetCollateral.String(), r.LockedUST.String(),
+		})
+	}
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/mirror-positions.csv", folder), []string{
+		"idx", "owner", "is_short",
+		"collateral_denom", "collateral", "collateral_price",
+		"minted_asset", "minted", "asset_price", "debt_uusd",
+		"net_collateral", "locked_uusd",
+	}, data)
+}
+
+// getCollateralPrices returns the uusd price of each relevant collateral
+func getCollateralPrices(app *terra.TerraApp, lunaXExchangeRate sdk.Dec) (map[string]sdk.Dec, error) {
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+
+	lunaPrice, err := app.OracleKeeper.GetLunaExchangeRate(sdk.UnwrapSDKContext(ctx), util.DenomUST)
+	if err != nil {
+		return nil, err
+	}
+
+	var state struct {
+		ExchangeRate sdk.Dec `json:"exchange_rate"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: anchor.MoneyMarketContract,
+		QueryMsg:        []byte("{\"epoch_state\": {}}"),
+	}, &state); err != nil {
+		return nil, err
+	}
+
+	return map[string]sdk.Dec{
+		util.DenomUST:  sdk.OneDec(),
+		util.AUST:      state.ExchangeRate,
+		util.DenomLUNA: lunaPrice,
+		addressLunaX:   lunaPrice.Mul(lunaXExchangeRate),
+	}, nil
+}
+
+// getAssetPrice returns the uusd price of a mAsset, delisted assets use their end price
+func getAssetPrice(ctx context.Context, q types.QueryServer, oracle string, mAsset string) (sdk.Dec, error) {
+	var assetConfig struct {
+		EndPrice *sdk.Dec `json:"end_price"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: MirrorMint,
+		QueryMsg:        []byte(fmt.Sprintf("{\"asset_config\":{\"asset_token\":\"%s\"}}", mAsset)),
+	}, &assetConfig); err != nil {
+		return sdk.Dec{}, err
+	}
+	if assetConfig.EndPrice != nil {
+		return *assetConfig.EndPrice, nil
+	}
+
+	var price struct {
+		Rate sdk.Dec `json:"rate"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: oracle,
+		QueryMsg:        []byte(fmt.Sprintf("{\"price\":{\"asset_token\":\"%s\"}}", mAsset)),
+	}, &price); err != nil {
+		return sdk.Dec{}, fmt.Errorf("unable to get price of %s: %v", mAsset, err)
+	}
+	return price.Rate, nil
+}
+
+// getLockedAmount returns UST from a short sale locked in the lock contract
+func getLockedAmount(ctx context.Context, q types.QueryServer, idx sdk.Int) (sdk.Int, error) {
+	var lockInfo struct {
+		LockedAmount sdk.Int `json:"locked_amount"`
+	}
+	if err := util.ContractQuery(ctx, q, &types.QueryContractStoreRequest{
+		ContractAddress: MirrorLock,
+		QueryMsg:        []byte(fmt.Sprintf("{\"position_lock_info\":{\"position_idx\":\"%s\"}}", idx)),
+	}, &lockInfo); err != nil {
+		// lock info is removed once released
+		if strings.Contains(err.Error(), "not found") {
+			return sdk.ZeroInt(), nil
+		}
+		return sdk.Int{}, fmt.Errorf("unable to query lock info of position %s: %v", idx, err)
+	}
+	if lockInfo.LockedAmount.IsNil() {
+		return sdk.ZeroInt(), nil
+	}
+	return lockInfo.LockedAmount, nil
+}
+
 type positionsRes struct {
 	Positions []position `json:"positions"`
 }
@@ -63,6 +63,9 @@ func ExportAllBondedLuna(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotB
 	return snapshot, nil
 }
 
+// ExportAllNativeBalances exports UST and LUNA bank balances.
+// Vesting accounts are credited in full (locked LUNA is still owned by the account),
+// their vested/unvested split is written by WriteVestingAccounts.
 func ExportAllNativeBalances(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
 	ctx := util.PrepCtx(app)
 	snapshot := make(util.SnapshotBalanceAggregateMap)
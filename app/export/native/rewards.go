@@ -0,0 +1,80 @@
+package native
+
+import (
+	"fmt"
+	"os"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/anchor"
+	"github.com/terra-money/core/app/export/util"
+)
+
+const (
+	rewardSourceDelegation = "delegation_reward"
+	rewardSourceCommission = "commission"
+)
+
+// ExportStakingRewards exports unclaimed delegator rewards and validator commission in LUNA and UST.
+// Validator periods are incremented on a cache context, so the app state is left untouched.
+// The per-source breakdown is written to staking-rewards.csv.
+func ExportStakingRewards(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+	app.Logger().Info("Exporting staking rewards and commission")
+	ctx := util.PrepCtx(app)
+	cacheCtx, _ := sdk.UnwrapSDKContext(ctx).CacheContext()
+
+	snapshot := make(util.SnapshotBalanceAggregateMap)
+	var breakdown [][]string
+	credit := func(addr string, source string, coins sdk.DecCoins) {
+		for _, denom := range []string{util.DenomLUNA, util.DenomUST} {
+			amount := coins.AmountOf(denom).TruncateInt()
+			if amount.IsZero() {
+				continue
+			}
+			snapshot.AppendOrAddBalance(addr, util.SnapshotBalance{
+				Denom:   denom,
+				Balance: amount,
+			})
+			breakdown = append(breakdown, []string{addr, source, denom, amount.String()})
+		}
+	}
+
+	// close the current period of every validator, as the rewards query does
+	endingPeriods := make(map[string]uint64)
+	validators := app.StakingKeeper.GetAllValidators(cacheCtx)
+	valMap := make(map[string]stakingtypes.Validator)
+	for _, v := range validators {
+		valMap[v.OperatorAddress] = v
+		endingPeriods[v.OperatorAddress] = app.DistrKeeper.IncrementValidatorPeriod(cacheCtx, v)
+
+		commission := app.DistrKeeper.GetValidatorAccumulatedCommission(cacheCtx, v.GetOperator())
+		credit(sdk.AccAddress(v.GetOperator()).String(), rewardSourceCommission, commission.Commission)
+	}
+
+	c := 0
+	app.StakingKeeper.IterateAllDelegations(cacheCtx, func(del stakingtypes.Delegation) (stop bool) {
+		// bLUNA hub rewards are handled by the lido exporter
+		if anchor.AddressBLUNAHub == del.DelegatorAddress {
+			return false
+		}
+
+		c += 1
+		if c%10000 == 0 {
+			app.Logger().Info(fmt.Sprintf("Calculating delegation rewards.. %d", c))
+		}
+		v, ok := valMap[del.ValidatorAddress]
+		if !ok {
+			return false
+		}
+		rewards := app.DistrKeeper.CalculateDelegationRewards(cacheCtx, v, del, endingPeriods[del.ValidatorAddress])
+		credit(del.DelegatorAddress, rewardSourceDelegation, rewards)
+		return false
+	})
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/staking-rewards.csv", folder), []string{"address", "source", "denom", "amount"}, breakdown)
+
+	return snapshot, nil
+}
@@ -0,0 +1,116 @@
+package native
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	vestexported "github.com/cosmos/cosmos-sdk/x/auth/vesting/exported"
+	authvestingtypes "github.com/cosmos/cosmos-sdk/x/auth/vesting/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+	vestingtypes "github.com/terra-money/core/x/vesting/types"
+)
+
+const (
+	VestingTypeLazyGraded = "lazy_graded"
+	VestingTypeContinuous = "continuous"
+	VestingTypeDelayed    = "delayed"
+	VestingTypePeriodic   = "periodic"
+)
+
+// VestingAccount is the LUNA vesting position of an account at the snapshot height,
+// together with the original schedule so that it can be recreated in the new genesis.
+type VestingAccount struct {
+	Address          string  `json:"address"`
+	Type             string  `json:"type"`
+	OriginalVesting  sdk.Int `json:"original_vesting"`
+	Vested           sdk.Int `json:"vested"`
+	Unvested         sdk.Int `json:"unvested"`
+	DelegatedVesting sdk.Int `json:"delegated_vesting"`
+	StartTime        int64   `json:"start_time"`
+	EndTime          int64   `json:"end_time"`
+
+	// only one of the following is set, depending on type
+	LazySchedules   vestingtypes.Schedules   `json:"lazy_schedules,omitempty"`
+	PeriodicPeriods authvestingtypes.Periods `json:"periodic_periods,omitempty"`
+}
+
+// ExportVestingAccounts classifies every vesting account holding LUNA and splits its LUNA into vested and unvested
+func ExportVestingAccounts(app *terra.TerraApp) ([]VestingAccount, error) {
+	ctx := util.PrepCtx(app)
+	uCtx := sdk.UnwrapSDKContext(ctx)
+	blockTime := uCtx.BlockTime()
+
+	var accounts []VestingAccount
+	var err error
+	app.AccountKeeper.IterateAccounts(uCtx, func(acc authtypes.AccountI) (stop bool) {
+		vacc, ok := acc.(vestexported.VestingAccount)
+		if !ok {
+			return false
+		}
+		originalVesting := vacc.GetOriginalVesting().AmountOf(util.DenomLUNA)
+		if originalVesting.IsZero() {
+			return false
+		}
+
+		va := VestingAccount{
+			Address:          acc.GetAddress().String(),
+			OriginalVesting:  originalVesting,
+			Vested:           vacc.GetVestedCoins(blockTime).AmountOf(util.DenomLUNA),
+			Unvested:         vacc.GetVestingCoins(blockTime).AmountOf(util.DenomLUNA),
+			DelegatedVesting: vacc.GetDelegatedVesting().AmountOf(util.DenomLUNA),
+			StartTime:        vacc.GetStartTime(),
+			EndTime:          vacc.GetEndTime(),
+		}
+
+		switch a := acc.(type) {
+		case *vestingtypes.LazyGradedVestingAccount:
+			va.Type = VestingTypeLazyGraded
+			if schedule, ok := a.GetVestingSchedule(util.DenomLUNA); ok {
+				va.LazySchedules = schedule.Schedules
+			}
+		case *authvestingtypes.ContinuousVestingAccount:
+			va.Type = VestingTypeContinuous
+		case *authvestingtypes.DelayedVestingAccount:
+			va.Type = VestingTypeDelayed
+		case *authvestingtypes.PeriodicVestingAccount:
+			va.Type = VestingTypePeriodic
+			va.PeriodicPeriods = a.VestingPeriods
+		default:
+			err = fmt.Errorf("unknown vesting account type %T for %s", acc, va.Address)
+			return true
+		}
+
+		accounts = append(accounts, va)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Address < accounts[j].Address
+	})
+	return accounts, nil
+}
+
+// WriteVestingAccounts writes the vested/unvested split and schedules of vesting accounts
+// to vesting-accounts for the new genesis
+func WriteVestingAccounts(app *terra.TerraApp) error {
+	vestingAccounts, err := ExportVestingAccounts(app)
+	if err != nil {
+		return err
+	}
+	unvested := sdk.ZeroInt()
+	for _, va := range vestingAccounts {
+		unvested = unvested.Add(va.Unvested)
+	}
+	app.Logger().Info(fmt.Sprintf("... %d vesting accounts, %s uluna unvested", len(vestingAccounts), unvested))
+
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	return util.SaveDataToFile(fmt.Sprintf("%s/vesting-accounts", folder), vestingAccounts)
+}
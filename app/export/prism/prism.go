@@ -42,11 +42,27 @@ func ExportContract(
 
 	snapshot := make(util.SnapshotBalanceAggregateMap)
 
+	// contracts handled explicitly
+	skip := map[string]bool{
+		PrismVault:          true,
+		PrismSwapCLunaPrism: true,
+		PrismSwapPLunaPrism: true,
+		PrismLimitOrder:     true,
+		AstroportCLunaUST:   true,
+		AstrportCLunaLuna:   true,
+		AstroportPLunaLuna:  true,
+	}
+
 	// 1. Resolve pLUNA in PrismSwap and add to snapshot
 	pLunaHolding, err := resolvePLunaHoldings(ctx, q, app.WasmKeeper, bl)
 	if err != nil {
 		return nil, err
 	}
+	// follow pLUNA deposited in prism contracts
+	pLunaHolding, reports, err := resolveContractHoldings(ctx, app.WasmKeeper, PrismPLuna, util.DenomPLUNA, pLunaHolding, skip, bl)
+	if err != nil {
+		return nil, err
+	}
 	for a, b := range pLunaHolding {
 		snapshot[a] = append(snapshot[a], util.SnapshotBalance{
 			Denom:   util.DenomPLUNA,
@@ -74,6 +90,13 @@ func ExportContract(
 	cLunaHolders = util.MergeMaps(cLunaHolders, cLunaHoldingInPair)
 	bl.RegisterAddress(util.MapContractToDenom(PrismCLuna), PrismVault)
 
+	// follow cLUNA deposited in prism contracts
+	cLunaHolders, cLunaReports, err := resolveContractHoldings(ctx, app.WasmKeeper, PrismCLuna, util.DenomCLUNA, cLunaHolders, skip, bl)
+	if err != nil {
+		return nil, err
+	}
+	reports = append(reports, cLunaReports...)
+
 	// 5. Accumulate everything into snapshot
 	for a, b := range cLunaHolders {
 		snapshot[a] = append(snapshot[a], util.SnapshotBalance{
@@ -100,6 +123,13 @@ func ExportContract(
 		})
 	}
 
+	// yLUNA staking & farms
+	yLunaReports, err := ExportYLunaHolders(app, bl)
+	if err != nil {
+		return nil, err
+	}
+	reportContracts(app, append(reports, yLunaReports...))
+
 	bl.RegisterAddress(util.DenomLUNA, PrismVault)
 	return snapshot, nil
 }
@@ -300,3 +330,9 @@ func checkUnbondingCLuna(
 	}
 	return nil
 }
+
+// GetCLunaExchangeRate returns the cLUNA to LUNA exchange rate of the prism vault
+func GetCLunaExchangeRate(ctx context.Context, q wasmtypes.QueryServer) (sdk.Dec, error) {
+	state, err := getPrismVaultState(ctx, q)
+	return state.ExchangeRate, err
+}
@@ -0,0 +1,197 @@
+package prism
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/util"
+	wasmkeeper "github.com/terra-money/core/x/wasm/keeper"
+)
+
+var (
+	PrismYLuna        = "terra17wkadg0tah554r35x6wvff0y5s7ve8npcjfuhz"
+	PrismYLunaStaking = "terra1p7jp8vlt57cf8qwazjg58qngwvarmszsamzaru"
+
+	// PrismContracts are the prism contracts holding a LUNA derivative on their users' behalf,
+	// besides the vault, swap pairs and limit orders handled explicitly. The Forge takes UST and the
+	// xPRISM vault takes PRISM, so neither holds a LUNA derivative.
+	PrismContracts = []prismContract{
+		{Address: PrismYLunaStaking, Token: PrismYLuna, Namespace: "staker_info"},
+	}
+)
+
+// prismContract is a prism contract recording deposits of token in a map of
+// user => {"bond_amount": ...} under namespace
+type prismContract struct {
+	Address   string
+	Token     string
+	Namespace string
+}
+
+// contractReport is a row of the prism contracts audit
+type contractReport struct {
+	Contract string
+	Token    string
+	Balance  sdk.Int
+	Decoded  sdk.Int
+	Prefix   string
+	Resolved bool
+}
+
+// resolveContractHoldings replaces prism contracts holding token on their users' behalf with their depositors.
+// Deposits are decoded from storage and only used if they match the contract's on-chain balance.
+// Other contracts holding token are only reported, they are left to the generic contract handling.
+// Resolved contracts are blacklisted for denom unless it is empty.
+func resolveContractHoldings(
+	ctx context.Context,
+	k wasmkeeper.Keeper,
+	token string,
+	denom string,
+	holdings map[string]sdk.Int,
+	skip map[string]bool,
+	bl util.Blacklist,
+) (map[string]sdk.Int, []contractReport, error) {
+	var contracts []string
+	for addr := range holdings {
+		if skip[addr] {
+			continue
+		}
+		if _, err := k.GetContractInfo(sdk.UnwrapSDKContext(ctx), util.ToAddress(addr)); err == nil {
+			contracts = append(contracts, addr)
+		}
+	}
+	sort.Strings(contracts)
+
+	var reports []contractReport
+	resolved := util.MergeMaps(holdings)
+	for _, contract := range contracts {
+		balance := holdings[contract]
+		if balance.IsZero() {
+			continue
+		}
+		report := contractReport{Contract: contract, Token: token, Balance: balance, Decoded: sdk.ZeroInt()}
+		pc, ok := findPrismContract(contract, token)
+		if !ok {
+			reports = append(reports, report)
+			continue
+		}
+
+		deposits, err := getDeposits(ctx, k, contract, pc.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoded := util.Sum(deposits)
+		report.Decoded, report.Prefix = decoded, pc.Namespace
+		if err := util.AlmostEqual(contract, balance, decoded, sdk.NewInt(1000000)); err != nil {
+			return nil, nil, fmt.Errorf("prism: deposits of %s don't match its %s balance: %v", contract, token, err)
+		}
+
+		// distribute the actual balance pro-rata to deposits
+		delete(resolved, contract)
+		for user, deposit := range deposits {
+			share := deposit.Mul(balance).Quo(decoded)
+			if resolved[user].IsNil() {
+				resolved[user] = share
+			} else {
+				resolved[user] = resolved[user].Add(share)
+			}
+		}
+		if denom != "" {
+			bl.RegisterAddress(denom, contract)
+		}
+		report.Resolved = true
+		reports = append(reports, report)
+	}
+	return resolved, reports, nil
+}
+
+func findPrismContract(contract string, token string) (prismContract, bool) {
+	for _, pc := range PrismContracts {
+		if pc.Address == contract && pc.Token == token {
+			return pc, true
+		}
+	}
+	return prismContract{}, false
+}
+
+// getDeposits reads the bond_amount of every user in a storage map
+func getDeposits(ctx context.Context, k wasmkeeper.Keeper, contract string, namespace string) (map[string]sdk.Int, error) {
+	deposits := make(map[string]sdk.Int)
+	var err error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(contract), util.GeneratePrefix(namespace), func(key, value []byte) bool {
+		var user string
+		if user, err = util.AddressFromKey(key); err != nil {
+			err = fmt.Errorf("unable to decode %s key of %s: %v", namespace, contract, err)
+			return true
+		}
+		var position struct {
+			BondAmount sdk.Int `json:"bond_amount"`
+		}
+		if err = json.Unmarshal(value, &position); err != nil || position.BondAmount.IsNil() {
+			err = fmt.Errorf("unable to decode %s of %s in %s: %v", namespace, user, contract, err)
+			return true
+		}
+		if !position.BondAmount.IsZero() {
+			deposits[user] = position.BondAmount
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deposits, nil
+}
+
+// ExportYLunaHolders writes yLUNA held directly or staked in prism contracts.
+// pLUNA is credited as cLUNA so yLUNA carries no principal, it is reported and not merged.
+func ExportYLunaHolders(app *terra.TerraApp, bl util.Blacklist) ([]contractReport, error) {
+	app.Logger().Info("Exporting Prism yLUNA holders")
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+
+	holders := make(map[string]sdk.Int)
+	if err := util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, PrismYLuna, holders); err != nil {
+		return nil, err
+	}
+	resolved, reports, err := resolveContractHoldings(ctx, app.WasmKeeper, PrismYLuna, "", holders, map[string]bool{}, bl)
+	if err != nil {
+		return nil, err
+	}
+
+	supply, err := util.GetCW20TotalSupply(ctx, q, PrismYLuna)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.AlmostEqual("yLuna doesn't match", supply, util.Sum(resolved), sdk.NewInt(200000)); err != nil {
+		app.Logger().Info(err.Error())
+	}
+
+	var data [][]string
+	for addr, amount := range resolved {
+		data = append(data, []string{addr, amount.String()})
+	}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/prism-yluna.csv", folder), []string{"address", "yluna"}, data)
+
+	return reports, nil
+}
+
+// reportContracts writes how prism contracts holding LUNA derivatives were resolved
+func reportContracts(app *terra.TerraApp, reports []contractReport) {
+	var data [][]string
+	for _, r := range reports {
+		if !r.Resolved {
+			app.Logger().Info(fmt.Sprintf("... contract %s holding %s %s is not a prism contract, left to contract handling", r.Contract, r.Balance, r.Token))
+		}
+		data = append(data, []string{r.Contract, r.Token, r.Balance.String(), r.Decoded.String(), r.Prefix, fmt.Sprintf("%t", r.Resolved)})
+	}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/prism-contracts.csv", folder), []string{"contract", "token", "balance", "decoded", "prefix", "resolved"}, data)
+}
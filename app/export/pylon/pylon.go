@@ -3,6 +3,8 @@ package pylon
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	// stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
@@ -14,6 +16,7 @@ import (
 )
 
 var (
+	// Known pylon pools, their code IDs are used to discover the others
 	PylonPools = []string{
 		// Mine
 		"terra1z5j60wct88yz62ylqa4t8p8239cwx9kjlghkg2",
@@ -48,6 +51,7 @@ var (
 	}
 
 	// Contracts found on https://api.pylon.money/api/gateway/v1/projects/
+	// Gateway pools not listed here are linked to their pool on discovery
 	PylonLookup = map[string][]string{
 		"terra1z5j60wct88yz62ylqa4t8p8239cwx9kjlghkg2": {
 			"terra19vnwdqz4um0z8f69pc8y0z4ncrcxm4cjf3gevz",
@@ -127,51 +131,96 @@ type PylonPoolConfig struct {
 	PoolToken  string `json:"dp_token"`
 }
 
+// ExportContract credits depositors of every pylon pool. Deposits are UST principal recorded by
+// the gateway pools, they are paid out of what the pool holds, in aUST for aUST-based pools, in
+// UST for UST-based ones, pro-rata to value for pools holding both.
 func ExportContract(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
 	app.Logger().Info("Exporting Pylon")
-	var _ wasmtypes.QueryServer
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
 	snapshot := make(util.SnapshotBalanceAggregateMap)
 
-	for _, pool := range PylonPools {
-		config, err := getConfig(ctx, q, app.BankKeeper, pool)
+	pools, err := discoverPools(ctx, app, q)
+	if err != nil {
+		return nil, err
+	}
+	aUstER, err := anchor.GetAUstExchangeRate(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var report [][]string
+	for _, pool := range sortedPools(pools) {
+		holding, err := getPoolHolding(ctx, q, app.BankKeeper, pool, pools[pool])
 		if err != nil {
 			return nil, err
 		}
 
-		tokenBalances := make(map[string]sdk.Int)
-		err = util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, config.PoolToken, tokenBalances)
-		if err != nil {
-			return nil, err
+		candidates := make(map[string]bool)
+		if holding.PoolToken != "" {
+			tokenBalances := make(map[string]sdk.Int)
+			err = util.GetCW20AccountsAndBalances2(ctx, app.WasmKeeper, holding.PoolToken, tokenBalances)
+			if err != nil {
+				return nil, err
+			}
+			for address := range tokenBalances {
+				candidates[address] = true
+			}
 		}
-		aUstER, err := anchor.GetAUstExchangeRate(app)
-		if err != nil {
+		deposits := make(map[string]sdk.Int)
+		for _, gateway := range pools[pool] {
+			gatewayDeposits, err := getDeposits(ctx, app.WasmKeeper, q, gateway, candidates)
+			if err != nil {
+				return nil, err
+			}
+			deposits = util.MergeMaps(deposits, gatewayDeposits)
+		}
+		totalDeposits := util.Sum(deposits)
+
+		// audit: the pool holds its depositors' principal, plus yield not yet claimed by the project
+		aUstValue := aUstER.MulInt(holding.AUstAmount)
+		value := aUstValue.Add(holding.UstAmount.ToDec())
+		if err := util.AlmostEqual(fmt.Sprintf("pylon pool %s", pool), value.TruncateInt(), totalDeposits, PoolAuditEpsilon); err != nil {
 			return nil, err
 		}
+		report = append(report, []string{
+			pool,
+			fmt.Sprintf("%d", len(pools[pool])),
+			fmt.Sprintf("%d", len(deposits)),
+			totalDeposits.String(),
+			holding.AUstAmount.String(),
+			holding.UstAmount.String(),
+		})
+		if totalDeposits.IsZero() || value.IsZero() {
+			continue
+		}
 
-		for address := range tokenBalances {
-			for _, individualPool := range PylonLookup[pool] {
-				var stakedBalance struct {
-					Amount sdk.Int `json:"amount"`
-				}
-				err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
-					ContractAddress: individualPool,
-					QueryMsg:        []byte(fmt.Sprintf("{\"balance_of\":{\"owner\":\"%s\"}}", address)),
-				}, &stakedBalance)
-				if err != nil {
-					return snapshot, err
-				}
-
-				if !stakedBalance.Amount.IsZero() {
-					snapshot.AppendOrAddBalance(address, util.SnapshotBalance{
-						Denom:   util.DenomAUST,
-						Balance: stakedBalance.Amount.ToDec().Quo(aUstER).TruncateInt(),
-					})
-				}
+		aUstFraction := aUstValue.Quo(value)
+		for address, deposit := range deposits {
+			if deposit.IsZero() {
+				continue
 			}
+			if aUst := aUstFraction.MulInt(deposit).Quo(aUstER).TruncateInt(); aUst.IsPositive() {
+				snapshot.AppendOrAddBalance(address, util.SnapshotBalance{
+					Denom:   util.DenomAUST,
+					Balance: aUst,
+				})
+			}
+			if ust := sdk.OneDec().Sub(aUstFraction).MulInt(deposit).TruncateInt(); ust.IsPositive() {
+				snapshot.AppendOrAddBalance(address, util.SnapshotBalance{
+					Denom:   util.DenomUST,
+					Balance: ust,
+				})
+			}
+		}
+		for _, addr := range append([]string{pool}, pools[pool]...) {
+			bl.RegisterAddress(util.DenomAUST, addr)
+			bl.RegisterAddress(util.DenomUST, addr)
 		}
 	}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/pylon-pools.csv", folder), []string{"pool", "gateway_pools", "depositors", "deposits_uusd", "pool_aust", "pool_uusd"}, report)
 
 	return snapshot, nil
 }
@@ -181,29 +230,69 @@ func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
 
-	totalAUst := sdk.NewInt(0)
-
 	aUstER, err := anchor.GetAUstExchangeRate(app)
 	if err != nil {
 		return err
 	}
+	pools, err := discoverPools(ctx, app, q)
+	if err != nil {
+		return err
+	}
 
-	for _, pool := range PylonPools {
-		config, err := getConfig(ctx, q, app.BankKeeper, pool)
+	// every pool was audited against its depositors on export, credits must match the pools' holdings
+	held := sdk.ZeroDec()
+	for pool, gateways := range pools {
+		holding, err := getPoolHolding(ctx, q, app.BankKeeper, pool, gateways)
 		if err != nil {
 			return err
 		}
-
-		totalAUst = totalAUst.Add(config.UstAmount.ToDec().QuoTruncate(aUstER).TruncateInt()).Add(config.AUstAmount)
+		held = held.Add(aUstER.MulInt(holding.AUstAmount)).Add(holding.UstAmount.ToDec())
 	}
-
-	if err := util.AlmostEqual(util.DenomUST, totalAUst, snapshot.SumOfDenom(util.DenomAUST), sdk.NewInt(100000000000)); err != nil {
+	credited := aUstER.MulInt(snapshot.SumOfDenom(util.DenomAUST)).Add(snapshot.SumOfDenom(util.DenomUST).ToDec())
+	if err := util.AlmostEqual("pylon", held.TruncateInt(), credited.TruncateInt(), PoolAuditEpsilon.MulRaw(int64(len(pools)))); err != nil {
 		return err
 	}
-
+	app.Logger().Info(fmt.Sprintf("... pylon credited %s uusd of %s uusd held", credited.TruncateInt(), held.TruncateInt()))
 	return nil
 }
 
+// getPoolHolding returns the config of a pool with the aUST and UST held by it and its gateway pools
+func getPoolHolding(ctx context.Context, q wasmtypes.QueryServer, k wasmtypes.BankKeeper, pool string, gateways []string) (PylonPoolConfig, error) {
+	config, err := getConfig(ctx, q, k, pool)
+	if err != nil {
+		// discovered pools may not answer the config query, holdings are still needed
+		config = PylonPoolConfig{Pool: pool}
+		if config.AUstAmount, err = util.GetCW20Balance(ctx, q, util.AUST, pool); err != nil {
+			return config, err
+		}
+		if config.UstAmount, err = util.GetNativeBalance(ctx, k, util.DenomUST, pool); err != nil {
+			return config, err
+		}
+	}
+	for _, gateway := range gateways {
+		aUst, err := util.GetCW20Balance(ctx, q, util.AUST, gateway)
+		if err != nil {
+			return config, err
+		}
+		ust, err := util.GetNativeBalance(ctx, k, util.DenomUST, gateway)
+		if err != nil {
+			return config, err
+		}
+		config.AUstAmount = config.AUstAmount.Add(aUst)
+		config.UstAmount = config.UstAmount.Add(ust)
+	}
+	return config, nil
+}
+
+func sortedPools(pools map[string][]string) []string {
+	var sorted []string
+	for pool := range pools {
+		sorted = append(sorted, pool)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
 func getConfig(ctx context.Context, q wasmtypes.QueryServer, k wasmtypes.BankKeeper, pool string) (PylonPoolConfig, error) {
 	var config PylonPoolConfig
 	err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
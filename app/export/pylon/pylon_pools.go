@@ -0,0 +1,171 @@
+package pylon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/generic/common"
+	util "github.com/terra-money/core/app/export/util"
+	"github.com/terra-money/core/x/wasm/keeper"
+	wasmtypes "github.com/terra-money/core/x/wasm/types"
+)
+
+var (
+	// GatewayDepositNamespace is the storage namespace of gateway pool deposits, keyed by depositor
+	GatewayDepositNamespace = "user"
+
+	// PoolAuditEpsilon is the uusd a pool may hold above or below its deposits, unclaimed yield included
+	PoolAuditEpsilon = sdk.NewInt(1000000000)
+)
+
+// discoverPools returns pylon pool => gateway pools holding its deposits.
+// Pools and gateway pools are all contracts sharing a code ID with the known ones, a gateway
+// pool belongs to the pool its init msg or config refers to.
+func discoverPools(ctx context.Context, app *terra.TerraApp, q wasmtypes.QueryServer) (map[string][]string, error) {
+	contractsMap := make(common.ContractsMap)
+	common.IterateAllContracts(sdk.UnwrapSDKContext(ctx), app.WasmKeeper, contractsMap)
+
+	poolCodes := make(map[uint64]bool)
+	gatewayCodes := make(map[uint64]bool)
+	for _, pool := range PylonPools {
+		if info, ok := contractsMap[pool]; ok {
+			poolCodes[info.CodeID] = true
+		}
+	}
+	for pool, gateways := range PylonLookup {
+		if info, ok := contractsMap[pool]; ok {
+			poolCodes[info.CodeID] = true
+		}
+		for _, gateway := range gateways {
+			if info, ok := contractsMap[gateway]; ok {
+				gatewayCodes[info.CodeID] = true
+			}
+		}
+	}
+
+	pools := make(map[string][]string)
+	var gateways []string
+	for addr, info := range contractsMap {
+		if poolCodes[info.CodeID] {
+			pools[addr] = nil
+		}
+		if gatewayCodes[info.CodeID] {
+			gateways = append(gateways, addr)
+		}
+	}
+	sort.Strings(gateways)
+
+	known := make(map[string]string)
+	for pool, poolGateways := range PylonLookup {
+		for _, gateway := range poolGateways {
+			known[gateway] = pool
+		}
+	}
+	var unlinked []string
+	for _, gateway := range gateways {
+		pool, ok := known[gateway]
+		if !ok {
+			pool = findReferencedPool(ctx, q, contractsMap[gateway].InitMsg, gateway, pools)
+		}
+		if pool == "" {
+			unlinked = append(unlinked, gateway)
+			continue
+		}
+		pools[pool] = append(pools[pool], gateway)
+	}
+	app.Logger().Info(fmt.Sprintf("... %d pylon pools, %d gateway pools, %d gateway pools without pool", len(pools), len(gateways), len(unlinked)))
+	for _, gateway := range unlinked {
+		app.Logger().Info(fmt.Sprintf("... gateway pool %s without pool", gateway))
+	}
+	return pools, nil
+}
+
+// findReferencedPool returns the pool whose address appears in the init msg or config of a gateway pool
+func findReferencedPool(ctx context.Context, q wasmtypes.QueryServer, initMsg []byte, gateway string, pools map[string][]string) string {
+	docs := []string{string(initMsg)}
+	res, err := q.RawStore(ctx, &wasmtypes.QueryRawStoreRequest{
+		ContractAddress: gateway,
+		Key:             []byte("config"),
+	})
+	if err == nil && res.Data != nil {
+		docs = append(docs, string(res.Data))
+	}
+	for _, doc := range docs {
+		for pool := range pools {
+			if strings.Contains(doc, pool) {
+				return pool
+			}
+		}
+	}
+	return ""
+}
+
+// getDeposits returns the UST deposited by every user of a gateway pool, read from its deposit
+// namespace. Gateway pools without one are queried for every candidate.
+func getDeposits(ctx context.Context, k keeper.Keeper, q wasmtypes.QueryServer, gateway string, candidates map[string]bool) (map[string]sdk.Int, error) {
+	deposits, found, err := decodeDeposits(ctx, k, gateway)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return deposits, nil
+	}
+
+	for addr := range candidates {
+		amount, err := getBalanceOf(ctx, q, gateway, addr)
+		if err != nil {
+			return nil, err
+		}
+		if !amount.IsZero() {
+			deposits[addr] = amount
+		}
+	}
+	return deposits, nil
+}
+
+// decodeDeposits returns address => amount of the deposit namespace of a gateway pool,
+// and whether the namespace has any entry
+func decodeDeposits(ctx context.Context, k keeper.Keeper, gateway string) (map[string]sdk.Int, bool, error) {
+	deposits := make(map[string]sdk.Int)
+	found := false
+	var err error
+	k.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), util.ToAddress(gateway), util.GeneratePrefix(GatewayDepositNamespace), func(key, value []byte) bool {
+		found = true
+		var addr string
+		if addr, err = util.AddressFromKey(key); err != nil {
+			err = fmt.Errorf("unable to decode depositor of %s: %v", gateway, err)
+			return true
+		}
+		var user struct {
+			Amount sdk.Int `json:"amount"`
+		}
+		if err = json.Unmarshal(value, &user); err != nil || user.Amount.IsNil() {
+			err = fmt.Errorf("unable to decode deposit of %s in %s: %v", addr, gateway, err)
+			return true
+		}
+		if !user.Amount.IsZero() {
+			deposits[addr] = user.Amount
+		}
+		return false
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return deposits, found, nil
+}
+
+func getBalanceOf(ctx context.Context, q wasmtypes.QueryServer, gateway string, owner string) (sdk.Int, error) {
+	var stakedBalance struct {
+		Amount sdk.Int `json:"amount"`
+	}
+	err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: gateway,
+		QueryMsg:        []byte(fmt.Sprintf("{\"balance_of\":{\"owner\":\"%s\"}}", owner)),
+	}, &stakedBalance)
+	return stakedBalance.Amount, err
+}
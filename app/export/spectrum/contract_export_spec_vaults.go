@@ -4,19 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/cosmos/cosmos-sdk/types"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	terra "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/dex"
 	util "github.com/terra-money/core/app/export/util"
 	"github.com/terra-money/core/x/wasm/keeper"
 	wasmtypes "github.com/terra-money/core/x/wasm/types"
 )
 
 var (
+	specGov     = "terra1dpe4fmcz2jqk6t50plw0gqa2q3he2tj6wex5cl"
 	nLunaFarm   = "terra16usjvptlpdrj7hcmy7mvdap5tttzcya7ch0can"
 	ustLunaFarm = "terra1egstlx9c9pq5taja5sg0yhraa0cl5laxyvm3ln"
-	specFarms   = []string{
+	// farms known before discovery from gov
+	specFarms = []string{
 		//SPEC-UST
 		"terra17hjvrkcwn3jk2qf69s5ldxx5rjccchu35assga",
 		//stLuna-LUNA
@@ -102,12 +106,25 @@ type RewardInfo struct {
 //    a. For each holder, call contract query `reward_info` to find the bond_amount.
 //        i. For each pool, add the LP tokens to the resulting map
 // 3. Return list of LP ownship group by LP token address and wallet address
+//
+// Farms are discovered from the gov contract. Rewards held by a farm and not yet reinvested
+// are credited to its stakers pro-rata to the uusd value of their bonds.
 func ExportSpecVaultLPs(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) (map[string]map[string]map[string]sdk.Int, error) {
 	app.Logger().Info("Exporting Specturm")
 	ctx := util.PrepCtx(app)
 	q := util.PrepWasmQueryServer(app)
+	farms, err := getSpecFarms(app, ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	lunaPrice, err := app.OracleKeeper.GetLunaExchangeRate(sdk.UnwrapSDKContext(ctx), util.DenomUST)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonTarget [][]string
 	holdings := make(map[string]map[string]map[string]sdk.Int)
-	for _, farmAddrStr := range specFarms {
+	for _, farmAddrStr := range farms {
 		farmAddr, err := sdk.AccAddressFromBech32(farmAddrStr)
 		if err != nil {
 			return nil, err
@@ -123,10 +140,164 @@ func ExportSpecVaultLPs(app *terra.TerraApp, snapshot util.SnapshotBalanceAggreg
 		if err != nil {
 			return nil, err
 		}
+
+		lpPools := make(map[string]dex.Pool)
+		for lpAddr, lpHolders := range holding {
+			pool, err := getLpPool(ctx, q, lpAddr)
+			if err != nil {
+				return nil, err
+			}
+			lpPools[lpAddr] = pool
+			if !dex.IsTargetPool(&pool) {
+				nonTarget = append(nonTarget, []string{farmAddrStr, lpAddr, util.Sum(lpHolders).String()})
+			}
+		}
+
+		if err := creditPendingRewards(app, ctx, q, farmAddrStr, holding, lpPools, lunaPrice, snapshot); err != nil {
+			return nil, err
+		}
 	}
+
+	app.Logger().Info(fmt.Sprintf("... %d spectrum farm pools hold LP of non-target pairs", len(nonTarget)))
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/spectrum-non-target.csv", folder), []string{"farm", "lp_token", "bond_amount"}, nonTarget)
+
 	return holdings, nil
 }
 
+// getSpecFarms returns farms registered in gov, along with the known farms
+func getSpecFarms(app *terra.TerraApp, ctx context.Context, q wasmtypes.QueryServer) ([]string, error) {
+	var vaults struct {
+		Vaults []struct {
+			Address string `json:"address"`
+		} `json:"vaults"`
+	}
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: specGov,
+		QueryMsg:        []byte("{\"vaults\":{}}"),
+	}, &vaults); err != nil {
+		return nil, fmt.Errorf("unable to query spectrum vaults: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	farms := append([]string{}, specFarms...)
+	for _, farm := range specFarms {
+		seen[farm] = true
+	}
+	for _, vault := range vaults.Vaults {
+		if seen[vault.Address] {
+			continue
+		}
+		seen[vault.Address] = true
+		farms = append(farms, vault.Address)
+		app.Logger().Info(fmt.Sprintf("... discovered spectrum farm %s", vault.Address))
+	}
+	return farms, nil
+}
+
+// getLpPool returns the pool of the pair minting an LP token
+func getLpPool(ctx context.Context, q wasmtypes.QueryServer, lpAddr string) (dex.Pool, error) {
+	var minter struct {
+		Minter string `json:"minter"`
+	}
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: lpAddr,
+		QueryMsg:        []byte("{\"minter\":{}}"),
+	}, &minter); err != nil {
+		return dex.Pool{}, fmt.Errorf("unable to query minter of %s: %v", lpAddr, err)
+	}
+	var pool dex.Pool
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: minter.Minter,
+		QueryMsg:        []byte("{\"pool\":{}}"),
+	}, &pool); err != nil {
+		return dex.Pool{}, fmt.Errorf("unable to query pool of %s: %v", minter.Minter, err)
+	}
+	return pool, nil
+}
+
+// lpValue returns the uusd value of one LP unit of a pool, from its UST or LUNA side.
+// Pools without either side are not valued.
+func lpValue(pool dex.Pool, lunaPrice sdk.Dec) (sdk.Dec, bool) {
+	if pool.TotalShare.IsNil() || pool.TotalShare.IsZero() {
+		return sdk.Dec{}, false
+	}
+	for _, asset := range pool.Assets {
+		switch dex.PickDenomOrContractAddress(asset.AssetInfo) {
+		case util.DenomUST:
+			return sdk.NewDecFromInt(asset.Amount.MulRaw(2)).QuoInt(pool.TotalShare), true
+		case util.DenomLUNA:
+			return lunaPrice.MulInt(asset.Amount.MulRaw(2)).QuoInt(pool.TotalShare), true
+		}
+	}
+	return sdk.Dec{}, false
+}
+
+// creditPendingRewards credits UST, LUNA, aUST and LUNA derivatives held by a farm before compounding
+// to its stakers, pro-rata to the uusd value of their bonds in all pools of the farm.
+// LP units of different pools are not comparable, so every pool is valued from its UST or LUNA side.
+func creditPendingRewards(
+	app *terra.TerraApp,
+	ctx context.Context,
+	q wasmtypes.QueryServer,
+	farmAddr string,
+	holding map[string]map[string]sdk.Int,
+	lpPools map[string]dex.Pool,
+	lunaPrice sdk.Dec,
+	snapshot util.SnapshotBalanceAggregateMap,
+) error {
+	bonds := make(map[string]sdk.Int)
+	for lpAddr, lpHolders := range holding {
+		value, ok := lpValue(lpPools[lpAddr], lunaPrice)
+		if !ok {
+			app.Logger().Error(fmt.Sprintf("... spectrum farm %s: LP %s has no UST or LUNA side, left out of pending rewards", farmAddr, lpAddr))
+			continue
+		}
+		for wallet, amount := range lpHolders {
+			if bonds[wallet].IsNil() {
+				bonds[wallet] = sdk.ZeroInt()
+			}
+			bonds[wallet] = bonds[wallet].Add(value.MulInt(amount).TruncateInt())
+		}
+	}
+	totalBond := util.Sum(bonds)
+	if totalBond.IsZero() {
+		return nil
+	}
+
+	pending := make(map[string]sdk.Int)
+	for _, denom := range []string{util.DenomUST, util.DenomLUNA} {
+		balance, err := util.GetNativeBalance(ctx, app.BankKeeper, denom, farmAddr)
+		if err != nil {
+			return err
+		}
+		pending[denom] = balance
+	}
+	for _, token := range []string{util.AddressAUST, util.AddressBLUNA, util.AddressSTLUNA, util.AddressNLUNA, util.AddressCLUNA, util.AddressLUNAX, util.AddressSTEAK} {
+		balance, err := util.GetCW20Balance(ctx, q, token, farmAddr)
+		if err != nil {
+			return err
+		}
+		denom, _ := dex.CoalesceToBalanceDenom(token)
+		pending[denom] = balance
+	}
+
+	for denom, balance := range pending {
+		if balance.IsZero() {
+			continue
+		}
+		app.Logger().Info(fmt.Sprintf("... spectrum farm %s holds %s %s pending compounding", farmAddr, balance, denom))
+		for wallet, bond := range bonds {
+			snapshot.AppendOrAddBalance(wallet, util.SnapshotBalance{
+				Denom:   denom,
+				Balance: balance.Mul(bond).Quo(totalBond),
+			})
+		}
+	}
+	return nil
+}
+
 func getSpecFarmPools(ctx context.Context, keeper keeper.Keeper, q wasmtypes.QueryServer, farmAddr sdk.AccAddress) (map[string]PoolInfo, error) {
 	prefix := util.GeneratePrefix("pool_info")
 	// var stratConfig StrategyConfig
@@ -39,6 +39,9 @@ func ExportLunaX(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAg
 		return nil, err
 	}
 
+	// batch id => LunaX to LUNA rate of undelegations
+	batchRates := make(map[int]sdk.Dec)
+
 	// balance * ER
 	for address, balance := range lunaxBalances {
 		if !balance.IsZero() {
@@ -59,9 +62,18 @@ func ExportLunaX(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAg
 				continue
 			}
 
+			rate, ok := batchRates[undelegation.BatchId]
+			if !ok {
+				rate, err = getBatchUndelegationRate(ctx, q, undelegation.BatchId, exchangeRate)
+				if err != nil {
+					return nil, err
+				}
+				batchRates[undelegation.BatchId] = rate
+			}
+
 			snapshot.AppendOrAddBalance(address, util.SnapshotBalance{
 				Denom:   util.DenomLUNA,
-				Balance: exchangeRate.MulInt(undelegation.TokenAmount).TruncateInt(),
+				Balance: rate.MulInt(undelegation.TokenAmount).TruncateInt(),
 			})
 		}
 	}
@@ -95,7 +107,7 @@ func ResolveToLuna(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMa
 
 	er, err := GetLunaXExchangeRate(ctx, qs)
 	if err != nil {
-		return fmt.Errorf("error fetching LunaX <> Luna ER", err)
+		return fmt.Errorf("error fetching LunaX <> Luna ER: %v", err)
 	}
 
 	for _, sbs := range snapshot {
@@ -142,19 +154,29 @@ func GetLunaXUndelegations(ctx context.Context, q wasmtypes.QueryServer, contrac
 	return undelegationRequests, nil
 }
 
-func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error {
-	app.Logger().Info("Audit -- LunaX")
-	ctx := util.PrepCtx(app)
-
-	lunaBalance, err := util.GetNativeBalance(ctx, app.BankKeeper, util.DenomLUNA, LunaXState)
-	if err != nil {
-		return err
+// getBatchUndelegationRate returns the LunaX to LUNA rate applied to an undelegation batch,
+// net of slashing once reconciled. Batches not undelegated yet use the current exchange rate.
+func getBatchUndelegationRate(ctx context.Context, q wasmtypes.QueryServer, batchID int, exchangeRate sdk.Dec) (sdk.Dec, error) {
+	var batchRes struct {
+		Batch *struct {
+			Reconciled             bool     `json:"reconciled"`
+			UndelegationER         sdk.Dec  `json:"undelegation_er"`
+			UnbondingSlashingRatio *sdk.Dec `json:"unbonding_slashing_ratio"`
+		} `json:"batch"`
 	}
-
-	// TODO: Need to also query staked Luna.
-	if err := util.AlmostEqual(util.DenomLUNA, lunaBalance, snapshot.SumOfDenom(util.DenomLUNA), sdk.NewInt(1000000)); err != nil {
-		return err
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: LunaXState,
+		QueryMsg:        []byte(fmt.Sprintf("{\"batch_undelegation\":{\"batch_id\":%d}}", batchID)),
+	}, &batchRes); err != nil {
+		return sdk.Dec{}, err
 	}
 
-	return nil
+	if batchRes.Batch == nil || batchRes.Batch.UndelegationER.IsNil() || batchRes.Batch.UndelegationER.IsZero() {
+		return exchangeRate, nil
+	}
+	rate := batchRes.Batch.UndelegationER
+	if batchRes.Batch.Reconciled && batchRes.Batch.UnbondingSlashingRatio != nil {
+		rate = rate.Mul(*batchRes.Batch.UnbondingSlashingRatio)
+	}
+	return rate, nil
 }
@@ -46,22 +46,21 @@ func ExportStakePlus(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalan
 	logger := app.Logger()
 	logger.Info("Exporting Stader Stake+ balances")
 
-	// get all stake+ contracts from registry
-	var staderContracts struct {
-		Contracts []string `json:"contracts"`
-	}
-	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
-		ContractAddress: StakeRegistry,
-		QueryMsg:        []byte("{\"get_staking_contracts\": {}}"),
-	}, &staderContracts); err != nil {
+	stakePlusContracts, err := getStakePlusContracts(ctx, q)
+	if err != nil {
 		return nil, err
 	}
 
-	for _, contract := range staderContracts.Contracts {
+	for _, contract := range stakePlusContracts {
 		// Exclude Fund contracts which don't have get_all_users queries.
 		if contains(StaderFundsContracts, contract) {
 			continue
 		}
+		// Exclude contracts whose users are exported by another product.
+		if contains(productContracts, contract) {
+			logger.Info(fmt.Sprintf("... skipping stake+ contract %s, exported by another product", contract))
+			continue
+		}
 
 		var stakePlusUsers struct {
 			UserInfo []struct {
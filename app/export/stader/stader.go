@@ -0,0 +1,176 @@
+package stader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	terra "github.com/terra-money/core/app"
+	wasmtypes "github.com/terra-money/core/x/wasm/types"
+
+	"github.com/terra-money/core/app/export/util"
+)
+
+var (
+	// productContracts hold the LUNA of LunaX, community farming pools and vaults
+	productContracts = []string{LunaXState, Pools, Delegator, SCC, Vaults}
+
+	// AuditEpsilon is the rounding tolerance of the audit, on top of pending staking rewards
+	AuditEpsilon = sdk.NewInt(1000000)
+)
+
+// ExportContract runs every Stader exporter (LunaX, community farming pools, Stake+ and vaults)
+// into a single snapshot. LunaX held by Stader contracts is dropped since their users are exported,
+// and Stake+ contracts already exported by another product are skipped so no position is credited twice.
+func ExportContract(app *terra.TerraApp, bl util.Blacklist) (util.SnapshotBalanceAggregateMap, error) {
+	app.Logger().Info("Exporting Stader")
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+
+	stakePlusContracts, err := getStakePlusContracts(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	lunaXSs, err := ExportLunaX(app, bl)
+	if err != nil {
+		return nil, err
+	}
+	for _, contract := range append(productContracts, stakePlusContracts...) {
+		if _, ok := lunaXSs[contract]; ok {
+			app.Logger().Info(fmt.Sprintf("... dropping LunaX worth %s uluna held by stader contract %s", lunaXSs.GetAddrBalance(contract, util.DenomLUNA), contract))
+			delete(lunaXSs, contract)
+		}
+	}
+
+	poolSs, err := ExportPools(app, bl)
+	if err != nil {
+		return nil, err
+	}
+	stakePlusSs, err := ExportStakePlus(app, bl)
+	if err != nil {
+		return nil, err
+	}
+	vaultSs, err := ExportVaults(app, bl)
+	if err != nil {
+		return nil, err
+	}
+
+	// users in more than one product hold distinct positions, their breakdown is written for review
+	products := map[string]util.SnapshotBalanceAggregateMap{
+		"lunax":      lunaXSs,
+		"pools":      poolSs,
+		"stake_plus": stakePlusSs,
+		"vaults":     vaultSs,
+	}
+	var names []string
+	users := make(map[string][]string)
+	for name, ss := range products {
+		names = append(names, name)
+		for addr := range ss {
+			users[addr] = append(users[addr], name)
+		}
+	}
+	sort.Strings(names)
+
+	var overlapping []string
+	for addr, in := range users {
+		if len(in) > 1 {
+			overlapping = append(overlapping, addr)
+		}
+	}
+	sort.Strings(overlapping)
+
+	var rows [][]string
+	for _, addr := range overlapping {
+		for _, name := range names {
+			if _, ok := products[name][addr]; ok {
+				rows = append(rows, []string{addr, name, products[name].GetAddrBalance(addr, util.DenomLUNA).String()})
+			}
+		}
+	}
+	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
+	_ = os.Mkdir(folder, 0777)
+	util.ToCsv(fmt.Sprintf("%s/stader-overlaps.csv", folder), []string{"address", "product", "uluna"}, rows)
+	app.Logger().Info(fmt.Sprintf("... %d stader users, %d in more than one product", len(users), len(overlapping)))
+
+	return util.MergeSnapshots(lunaXSs, poolSs, stakePlusSs, vaultSs), nil
+}
+
+// Audit reconciles LUNA credited by ExportContract with LUNA delegated, unbonding and held by Stader contracts.
+// Staking rewards not yet claimed by those contracts are allowed on top of AuditEpsilon.
+func Audit(app *terra.TerraApp, snapshot util.SnapshotBalanceAggregateMap) error {
+	app.Logger().Info("Audit -- Stader")
+	ctx := util.PrepCtx(app)
+	q := util.PrepWasmQueryServer(app)
+	uCtx := sdk.UnwrapSDKContext(ctx)
+	cacheCtx, _ := uCtx.CacheContext()
+
+	stakePlusContracts, err := getStakePlusContracts(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	// every delegator is counted once, even if it is also registered as a stake+ contract
+	delegators := []string{LunaXState, Delegator, SCC}
+	for _, contract := range stakePlusContracts {
+		if !contains(productContracts, contract) {
+			delegators = append(delegators, contract)
+		}
+	}
+
+	// validator periods are closed on a cache context, as in the rewards query
+	endingPeriods := make(map[string]uint64)
+	tvl := sdk.ZeroInt()
+	pending := sdk.ZeroInt()
+	for _, delegator := range delegators {
+		delAddr := util.ToAddress(delegator)
+		for _, del := range app.StakingKeeper.GetDelegatorDelegations(uCtx, delAddr, math.MaxUint16) {
+			validator, found := app.StakingKeeper.GetValidator(uCtx, del.GetValidatorAddr())
+			if !found {
+				continue
+			}
+			tvl = tvl.Add(validator.TokensFromShares(del.Shares).TruncateInt())
+
+			period, ok := endingPeriods[validator.OperatorAddress]
+			if !ok {
+				period = app.DistrKeeper.IncrementValidatorPeriod(cacheCtx, validator)
+				endingPeriods[validator.OperatorAddress] = period
+			}
+			rewards := app.DistrKeeper.CalculateDelegationRewards(cacheCtx, validator, del, period)
+			pending = pending.Add(rewards.AmountOf(util.DenomLUNA).TruncateInt())
+		}
+		for _, ubd := range app.StakingKeeper.GetUnbondingDelegations(uCtx, delAddr, math.MaxUint16) {
+			for _, entry := range ubd.Entries {
+				tvl = tvl.Add(entry.Balance)
+			}
+		}
+		balance, err := util.GetNativeBalance(ctx, app.BankKeeper, util.DenomLUNA, delegator)
+		if err != nil {
+			return err
+		}
+		tvl = tvl.Add(balance)
+	}
+
+	credited := snapshot.SumOfDenom(util.DenomLUNA)
+	app.Logger().Info(fmt.Sprintf("... stader tvl %s uluna, credited %s uluna, pending rewards %s uluna", tvl, credited, pending))
+
+	return util.AlmostEqual("stader tvl", tvl, credited, AuditEpsilon.Add(pending))
+}
+
+// getStakePlusContracts returns all stake+ contracts from registry
+func getStakePlusContracts(ctx context.Context, q wasmtypes.QueryServer) ([]string, error) {
+	var staderContracts struct {
+		Contracts []string `json:"contracts"`
+	}
+	if err := util.ContractQuery(ctx, q, &wasmtypes.QueryContractStoreRequest{
+		ContractAddress: StakeRegistry,
+		QueryMsg:        []byte("{\"get_staking_contracts\": {}}"),
+	}, &staderContracts); err != nil {
+		return nil, err
+	}
+	return staderContracts.Contracts, nil
+}
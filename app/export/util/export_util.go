@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	storetypes "github.com/cosmos/cosmos-sdk/store/types"
 	"github.com/cosmos/cosmos-sdk/types"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 	sdktypes "github.com/cosmos/cosmos-sdk/types"
@@ -115,14 +116,43 @@ var timestampsPerBlock = map[int64]time.Time{
 	7811404: time.Unix(1653717601, 0),
 }
 
-func PrepCtx(app *terra.TerraApp) context.Context {
+// ApolloSnapshotHeights are the heights of the Apollo reward snapshots, in order
+var ApolloSnapshotHeights = []int64{7563136, 7583084, 7592133, 7615292, 7741139, 7811404}
+
+// BlockTime returns the time of the app's last block, either from the known timestamps
+// or from the historical info the staking module keeps of recent blocks
+func BlockTime(app *terra.TerraApp) (time.Time, error) {
 	height := app.LastBlockHeight()
-	time, ok := timestampsPerBlock[height]
+	if t, ok := timestampsPerBlock[height]; ok {
+		return t, nil
+	}
+	info, found := app.StakingKeeper.GetHistoricalInfo(app.NewUncachedContext(true, tmproto.Header{Height: height}), height)
+	if !found {
+		return time.Time{}, fmt.Errorf("unknown block time of height %d", height)
+	}
+	return info.Header.Time, nil
+}
+
+// LoadHeight moves an app that was already loaded to another height of its store.
+// BaseApp.LoadHeight seals the app and can only run once.
+func LoadHeight(app *terra.TerraApp, height int64) error {
+	cms, ok := app.NewUncachedContext(true, tmproto.Header{}).MultiStore().(storetypes.CommitMultiStore)
 	if !ok {
-		panic(fmt.Sprintf("Unknown target height %d", height))
+		return fmt.Errorf("app store can not be reloaded")
+	}
+	return cms.LoadVersion(height)
+}
+
+func PrepCtx(app *terra.TerraApp) context.Context {
+	height := app.LastBlockHeight()
+	time, err := BlockTime(app)
+	if err != nil {
+		panic(err)
 	}
 
-	ctx := app.NewContext(true, tmproto.Header{Height: height, Time: time})
+	// read from the store directly, so the context follows LoadHeight
+	ctx := app.NewUncachedContext(true, tmproto.Header{Height: height, Time: time})
+	ctx = ctx.WithMultiStore(ctx.MultiStore().CacheMultiStore())
 	return sdktypes.WrapSDKContext(ctx)
 }
 
@@ -181,21 +211,22 @@ func GetCW20AccountsAndBalances2(ctx context.Context, keeper wasmkeeper.Keeper,
 	if err != nil {
 		return err
 	}
+	var iterErr error
 	keeper.IterateContractStateWithPrefix(sdk.UnwrapSDKContext(ctx), contractAddr, prefix, func(key, value []byte) bool {
 		// first and last byte is not used
 		balance, ok := sdktypes.NewIntFromString(string(value[1 : len(value)-1]))
 		// fmt.Printf("%x, %x, %s, %v\n", key, value, balance, ok)
 		if ok {
-			if strings.Contains(string(key), "terra") {
-				balanceMap[string(key)] = balance
-			} else {
-				addr := sdk.AccAddress(key)
-				balanceMap[addr.String()] = balance
+			addr, err := AddressFromKey(key)
+			if err != nil {
+				iterErr = fmt.Errorf("unable to parse cw20 holder of %s: %v", contractAddress, err)
+				return true
 			}
+			balanceMap[addr] = balance
 		}
 		return false
 	})
-	return nil
+	return iterErr
 }
 
 func ContractQuery(ctx context.Context, q wasmtypes.QueryServer, req *wasmtypes.QueryContractStoreRequest, res interface{}) error {
@@ -439,11 +470,11 @@ func CachedMap3(f func(*terra.TerraApp, SnapshotBalanceAggregateMap) (map[string
 	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
 	_ = os.Mkdir(folder, 0777)
 	path := fmt.Sprintf("%s/%s", folder, filename)
-	ssPath := fmt.Sprintf("%s-snapshot", path)
+	ssPath := fmt.Sprintf("%s-credits", path)
 	if _, err := os.Stat(path); err == nil {
+		var ss SnapshotBalanceAggregateMap
 		ssData, err := os.ReadFile(ssPath)
 		if err == nil {
-			var ss SnapshotBalanceAggregateMap
 			if err = json.Unmarshal(ssData, &ss); err != nil {
 				panic(err)
 			}
@@ -452,14 +483,18 @@ func CachedMap3(f func(*terra.TerraApp, SnapshotBalanceAggregateMap) (map[string
 		if err == nil {
 			var lpHolding map[string]map[string]map[string]sdk.Int
 			if err = json.Unmarshal(data, &lpHolding); err == nil {
+				mergeInto(snapshot, ss)
 				return lpHolding, nil
 			}
 		}
 	}
-	lpHolding, err := f(app, snapshot)
+	// f only sees its own credits, so that they can be cached and merged back on a cache hit
+	added := make(SnapshotBalanceAggregateMap)
+	lpHolding, err := f(app, added)
 	if err != nil {
 		return nil, err
 	}
+	mergeInto(snapshot, added)
 	out, err := json.MarshalIndent(lpHolding, "", "  ")
 	if err != nil {
 		return nil, err
@@ -468,7 +503,7 @@ func CachedMap3(f func(*terra.TerraApp, SnapshotBalanceAggregateMap) (map[string
 	if err != nil {
 		return nil, err
 	}
-	ssOut, err := json.MarshalIndent(snapshot, "", "  ")
+	ssOut, err := json.MarshalIndent(added, "", "  ")
 	if err != nil {
 		return nil, err
 	}
@@ -479,6 +514,15 @@ func CachedMap3(f func(*terra.TerraApp, SnapshotBalanceAggregateMap) (map[string
 	return lpHolding, nil
 }
 
+// mergeInto adds every balance of src to dst
+func mergeInto(dst SnapshotBalanceAggregateMap, src SnapshotBalanceAggregateMap) {
+	for addr, sbs := range src {
+		for _, sb := range sbs {
+			dst.AppendOrAddBalance(addr, sb)
+		}
+	}
+}
+
 func SaveToFile(app *terra.TerraApp, snapshot SnapshotBalanceAggregateMap, filename string) error {
 	folder := fmt.Sprintf("./cache-%d", app.LastBlockHeight())
 	_ = os.Mkdir(folder, 0777)
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// SplitKey splits the key of a map entry, with its namespace prefix removed, into parts.
+// Every part of a composite key but the last one is prefixed with its 2 byte length.
+func SplitKey(key []byte, parts int) ([][]byte, error) {
+	var split [][]byte
+	for i := 0; i < parts-1; i++ {
+		if len(key) < 2 {
+			return nil, fmt.Errorf("key %x has less than %d parts", key, parts)
+		}
+		n := int(binary.BigEndian.Uint16(key))
+		if len(key) < 2+n {
+			return nil, fmt.Errorf("key %x has less than %d parts", key, parts)
+		}
+		split = append(split, key[2:2+n])
+		key = key[2+n:]
+	}
+	return append(split, key), nil
+}
+
+// AddressFromKey decodes an address key part. Newer contracts store the bech32 string,
+// older ones the canonical address.
+func AddressFromKey(key []byte) (string, error) {
+	if addr, err := sdk.AccAddressFromBech32(string(key)); err == nil {
+		return addr.String(), nil
+	}
+	if len(key) == 20 || len(key) == 32 {
+		return sdk.AccAddress(key).String(), nil
+	}
+	return "", fmt.Errorf("key %x is not an address", key)
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"testing"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+func TestSplitKey(t *testing.T) {
+	key := append([]byte{0, 4}, []byte("uusdterra1")...)
+
+	parts, err := SplitKey(key, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(parts[0]) != "uusd" || string(parts[1]) != "terra1" {
+		t.Errorf("unexpected parts %q", parts)
+	}
+
+	if _, err := SplitKey([]byte{0, 9, 1}, 2); err == nil {
+		t.Errorf("expected an error on a truncated key")
+	}
+}
+
+func TestAddressFromKey(t *testing.T) {
+	canonical := make([]byte, 20)
+	canonical[19] = 1
+	bech32 := sdk.AccAddress(canonical).String()
+
+	for _, key := range [][]byte{canonical, []byte(bech32)} {
+		addr, err := AddressFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != bech32 {
+			t.Errorf("expected %s, got %s", bech32, addr)
+		}
+	}
+
+	if _, err := AddressFromKey([]byte("uusd")); err == nil {
+		t.Errorf("expected an error on a non address key")
+	}
+}
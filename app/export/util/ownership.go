@@ -0,0 +1,146 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// OwnershipGraph maps contract => token => holder => amount, as reported by the compounder
+// exporters. A contract holding a token is a node whose balance is pushed down to its users
+// pro-rata. A contract whose users hold a share token instead (a vault share, an aUST wrapper)
+// is followed through ShareTokens, so holders are resolved across tokens.
+type OwnershipGraph map[string]map[string]map[string]sdk.Int
+
+// ShareToken is what a share token is backed by: one share is worth Ratio of the Underlying token
+// held by the contract issuing the share.
+type ShareToken struct {
+	Underlying string
+	Ratio      sdk.Dec
+}
+
+// ShareTokens maps share token => backing token
+type ShareTokens map[string]ShareToken
+
+// RoundingReport is the truncation dust left at one hop of the resolution
+type RoundingReport struct {
+	Token       string
+	Contract    string
+	Depth       int
+	Amount      sdk.Int
+	Distributed sdk.Int
+	Dust        sdk.Int
+}
+
+// Resolve recursively pushes every holding of a token owned by a contract in the graph down
+// to the end holders, until only holders that are not nodes for the token remain.
+// Returns an error when the ownership contains a cycle.
+func (g OwnershipGraph) Resolve(token string, holdings BalanceMap) (BalanceMap, []RoundingReport, error) {
+	return g.ResolveShares(token, holdings, nil)
+}
+
+// ResolveShares is Resolve following share tokens as well: a contract that is not a node for a token
+// but whose users hold one of its share tokens converts the token it holds into shares at the share's
+// ratio, and the shares are pushed down to their holders. End holders are credited in the original token.
+func (g OwnershipGraph) ResolveShares(token string, holdings BalanceMap, shares ShareTokens) (BalanceMap, []RoundingReport, error) {
+	resolved := make(BalanceMap)
+	var reports []RoundingReport
+
+	var holders []string
+	for addr := range holdings {
+		holders = append(holders, addr)
+	}
+	sort.Strings(holders)
+
+	r := resolver{graph: g, shares: shares, resolved: resolved, reports: &reports}
+	for _, addr := range holders {
+		if err := r.push(token, sdk.OneDec(), addr, holdings[addr], nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	return resolved, reports, nil
+}
+
+type resolver struct {
+	graph    OwnershipGraph
+	shares   ShareTokens
+	resolved BalanceMap
+	reports  *[]RoundingReport
+}
+
+// push distributes amount of token held by addr. scale is the value of one token in the resolved token.
+func (r resolver) push(token string, scale sdk.Dec, addr string, amount sdk.Int, path []string) error {
+	if amount.IsNil() || amount.IsZero() {
+		return nil
+	}
+
+	users, isNode := r.graph[addr][token]
+	if !isNode || Sum(users).IsZero() {
+		share, ok := r.shareOf(addr, token)
+		if !ok {
+			credit := scale.MulInt(amount).TruncateInt()
+			if r.resolved[addr].IsNil() {
+				r.resolved[addr] = credit
+			} else {
+				r.resolved[addr] = r.resolved[addr].Add(credit)
+			}
+			return nil
+		}
+		// the users of addr hold its share token, worth Ratio of the token it holds
+		backing := r.shares[share]
+		token, scale = share, scale.Mul(backing.Ratio)
+		amount = sdk.NewDecFromInt(amount).Quo(backing.Ratio).TruncateInt()
+		users = r.graph[addr][share]
+	}
+
+	for _, p := range path {
+		if p == addr {
+			return fmt.Errorf("ownership cycle for %s: %s -> %s", token, strings.Join(path, " -> "), addr)
+		}
+	}
+	path = append(path, addr)
+	total := Sum(users)
+
+	var holders []string
+	for user := range users {
+		holders = append(holders, user)
+	}
+	sort.Strings(holders)
+
+	distributed := sdk.ZeroInt()
+	for _, user := range holders {
+		share := users[user].Mul(amount).Quo(total)
+		if err := r.push(token, scale, user, share, path); err != nil {
+			return err
+		}
+		distributed = distributed.Add(share)
+	}
+
+	*r.reports = append(*r.reports, RoundingReport{
+		Token:       token,
+		Contract:    addr,
+		Depth:       len(path),
+		Amount:      amount,
+		Distributed: distributed,
+		Dust:        amount.Sub(distributed),
+	})
+	return nil
+}
+
+// shareOf returns the share token of addr backed by token, whose holders are known
+func (r resolver) shareOf(addr string, token string) (string, bool) {
+	var tokens []string
+	for t := range r.graph[addr] {
+		tokens = append(tokens, t)
+	}
+	sort.Strings(tokens)
+	for _, t := range tokens {
+		backing, ok := r.shares[t]
+		if ok && backing.Underlying == token && !backing.Ratio.IsNil() && backing.Ratio.IsPositive() && !Sum(r.graph[addr][t]).IsZero() {
+			return t, true
+		}
+	}
+	return "", false
+}
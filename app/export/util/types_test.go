@@ -97,3 +97,121 @@ func TestMergeSnapshotMultipleBalances(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestOwnershipGraphResolve(t *testing.T) {
+	// vault2 holds a share of vault1, which holds the LP
+	g := OwnershipGraph{
+		"vault1": {
+			"lp": {
+				"addr1":  sdk.NewInt(100),
+				"vault2": sdk.NewInt(200),
+			},
+		},
+		"vault2": {
+			"lp": {
+				"addr2": sdk.NewInt(1),
+				"addr3": sdk.NewInt(2),
+			},
+		},
+	}
+
+	resolved, reports, err := g.Resolve("lp", BalanceMap{
+		"addr4":  sdk.NewInt(50),
+		"vault1": sdk.NewInt(301),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := resolved["vault1"]; ok {
+		t.Fail()
+	}
+	if _, ok := resolved["vault2"]; ok {
+		t.Fail()
+	}
+	if !resolved["addr1"].Equal(sdk.NewInt(100)) || !resolved["addr4"].Equal(sdk.NewInt(50)) {
+		t.Fail()
+	}
+	// vault2 receives 200 of 301, split 1:2
+	if !resolved["addr2"].Equal(sdk.NewInt(66)) || !resolved["addr3"].Equal(sdk.NewInt(133)) {
+		t.Fail()
+	}
+	if len(reports) != 2 {
+		t.Fail()
+	}
+}
+
+func TestOwnershipGraphCycle(t *testing.T) {
+	g := OwnershipGraph{
+		"vault1": {"lp": {"vault2": sdk.NewInt(1)}},
+		"vault2": {"lp": {"vault1": sdk.NewInt(1)}},
+	}
+
+	if _, _, err := g.Resolve("lp", BalanceMap{"vault1": sdk.NewInt(1)}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestOwnershipGraphResolveShares(t *testing.T) {
+	// vault holds the LP, its users hold vault shares worth 2 LP each, spec holds some of the shares
+	g := OwnershipGraph{
+		"vault": {
+			"share": {
+				"addr1": sdk.NewInt(100),
+				"spec":  sdk.NewInt(300),
+			},
+		},
+		"spec": {
+			"share": {
+				"addr2": sdk.NewInt(1),
+				"addr3": sdk.NewInt(2),
+			},
+		},
+	}
+	shares := ShareTokens{"share": {Underlying: "lp", Ratio: sdk.NewDec(2)}}
+
+	resolved, reports, err := g.ResolveShares("lp", BalanceMap{
+		"addr4": sdk.NewInt(50),
+		"vault": sdk.NewInt(800),
+	}, shares)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := resolved["vault"]; ok {
+		t.Fail()
+	}
+	if _, ok := resolved["spec"]; ok {
+		t.Fail()
+	}
+	if !resolved["addr1"].Equal(sdk.NewInt(200)) || !resolved["addr4"].Equal(sdk.NewInt(50)) {
+		t.Fail()
+	}
+	if !resolved["addr2"].Equal(sdk.NewInt(200)) || !resolved["addr3"].Equal(sdk.NewInt(400)) {
+		t.Fail()
+	}
+	if len(reports) != 2 || reports[1].Token != "share" {
+		t.Fail()
+	}
+
+	// without share tokens the vault keeps the LP
+	resolved, _, err = g.Resolve("lp", BalanceMap{"vault": sdk.NewInt(800)})
+	if err != nil || !resolved["vault"].Equal(sdk.NewInt(800)) {
+		t.Fail()
+	}
+}
+
+func TestOwnershipGraphShareCycle(t *testing.T) {
+	g := OwnershipGraph{
+		"vault1": {"share1": {"vault2": sdk.NewInt(1)}, "share2": {"vault2": sdk.NewInt(1)}},
+		"vault2": {"share2": {"vault1": sdk.NewInt(1)}},
+	}
+	shares := ShareTokens{
+		"share1": {Underlying: "lp", Ratio: sdk.OneDec()},
+		"share2": {Underlying: "share1", Ratio: sdk.OneDec()},
+	}
+
+	if _, _, err := g.ResolveShares("lp", BalanceMap{"vault1": sdk.NewInt(1)}, shares); err == nil {
+		t.Fail()
+	}
+}
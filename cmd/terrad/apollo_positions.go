@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/terra-money/core/app/export/apollo"
+	"github.com/terra-money/core/app/export/util"
+)
+
+// apolloPositionsCmd exports the unified position of every Apollo user at one height.
+func apolloPositionsCmd(a appCreator) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apollo-positions",
+		Short: "Export Apollo positions per user",
+		Long:  "Export strategy shares, pending rewards, CFE claimables and external APOLLO holdings of every Apollo user into apollo-positions.json.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputDir, _ := cmd.Flags().GetString(flagOutputDir)
+
+			terraApp, db, err := loadExportApp(cmd, a)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			positions, err := apollo.ExportUserPositions(terraApp)
+			if err != nil {
+				return err
+			}
+			return util.SaveDataToFile(filepath.Join(outputDir, "apollo-positions.json"), positions)
+		},
+	}
+	addExportAppFlags(cmd)
+	cmd.Flags().String(flagOutputDir, ".", "Directory to write apollo-positions.json to")
+	return cmd
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cosmos/cosmos-sdk/client/flags"
+	"github.com/cosmos/cosmos-sdk/server"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/spf13/cobra"
+
+	terraapp "github.com/terra-money/core/app"
+	"github.com/terra-money/core/app/export/apollo"
+	"github.com/terra-money/core/app/export/util"
+	wasmconfig "github.com/terra-money/core/x/wasm/config"
+)
+
+// apolloTimelineCmd exports Apollo vault and CFE rewards at several heights
+// and writes how each user's rewards evolved between them.
+func apolloTimelineCmd(a appCreator) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apollo-timeline [height...]",
+		Short: "Export Apollo rewards per user across heights",
+		Long:  "Export Apollo rewards per user across heights, defaulting to the Apollo snapshot heights. Heights pruned from the node, or whose block time is no longer kept by the staking module, are skipped.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverCtx := server.GetServerContextFromCmd(cmd)
+			homeDir, _ := cmd.Flags().GetString(flags.FlagHome)
+			outputDir, _ := cmd.Flags().GetString(flagOutputDir)
+			if err := applyExportFlags(serverCtx.Viper); err != nil {
+				return err
+			}
+
+			heights := util.ApolloSnapshotHeights
+			if len(args) > 0 {
+				heights = nil
+				for _, arg := range args {
+					height, err := strconv.ParseInt(arg, 10, 64)
+					if err != nil {
+						return fmt.Errorf("invalid height %s: %v", arg, err)
+					}
+					heights = append(heights, height)
+				}
+			}
+
+			db, err := sdk.NewLevelDB("application", filepath.Join(homeDir, "data"))
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			terraApp := terraapp.NewTerraApp(serverCtx.Logger, db, nil, false, map[int64]bool{}, homeDir, 0, a.encodingConfig, serverCtx.Viper, wasmconfig.DefaultConfig())
+			loaded := false
+
+			var snapshots []apollo.RewardSnapshot
+			for _, height := range heights {
+				// the first height seals the app, later ones only reload its store
+				if loaded {
+					err = util.LoadHeight(terraApp, height)
+				} else {
+					err = terraApp.LoadHeight(height)
+				}
+				if err != nil {
+					serverCtx.Logger.Error(fmt.Sprintf("skipping height %d: %v", height, err))
+					continue
+				}
+				loaded = true
+				if _, err := util.BlockTime(terraApp); err != nil {
+					serverCtx.Logger.Error(fmt.Sprintf("skipping height %d: %v", height, err))
+					continue
+				}
+
+				snapshot, err := apollo.ExportRewardSnapshot(terraApp)
+				if err != nil {
+					return err
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+			if len(snapshots) == 0 {
+				return fmt.Errorf("none of the heights %v could be loaded", heights)
+			}
+
+			return apollo.WriteRewardTimeline(outputDir, apollo.BuildRewardTimeline(snapshots))
+		},
+	}
+	cmd.Flags().String(flags.FlagHome, terraapp.DefaultNodeHome, "The application home directory")
+	cmd.Flags().String(flagOutputDir, ".", "Directory to write apollo-reward-timeline.json and .csv to")
+	addExportFlags(cmd)
+	return cmd
+}
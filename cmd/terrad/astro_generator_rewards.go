@@ -0,0 +1,39 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	terraexport "github.com/terra-money/core/app/export"
+	"github.com/terra-money/core/app/export/astroport"
+	"github.com/terra-money/core/app/export/util"
+)
+
+// astroGeneratorRewardsCmd exports pending Astroport generator rewards for reward compensation.
+// They are paid in ASTRO and proxy reward tokens, so they are kept out of the genesis balances.
+func astroGeneratorRewardsCmd(a appCreator) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "astro-generator-rewards",
+		Short: "Export pending Astroport generator rewards per user",
+		Long:  "Export pending ASTRO and proxy rewards of every Astroport generator depositor into astro-generator-rewards.json, one denom per reward token.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputDir, _ := cmd.Flags().GetString(flagOutputDir)
+
+			terraApp, db, err := loadExportApp(cmd, a)
+			if err != nil {
+				return err
+			}
+			defer db.Close()
+
+			rewards, err := astroport.ExportGeneratorRewards(terraApp, terraexport.NewBlacklist())
+			if err != nil {
+				return err
+			}
+			return util.SaveDataToFile(filepath.Join(outputDir, "astro-generator-rewards.json"), rewards)
+		},
+	}
+	addExportAppFlags(cmd)
+	cmd.Flags().String(flagOutputDir, ".", "Directory to write astro-generator-rewards.json to")
+	return cmd
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/cosmos/cosmos-sdk/client/flags"
+	"github.com/cosmos/cosmos-sdk/server"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/spf13/cobra"
+	dbm "github.com/tendermint/tm-db"
+
+	terraapp "github.com/terra-money/core/app"
+	wasmconfig "github.com/terra-money/core/x/wasm/config"
+)
+
+// addExportAppFlags adds the flags read by loadExportApp
+func addExportAppFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flags.FlagHome, terraapp.DefaultNodeHome, "The application home directory")
+	cmd.Flags().Int64(flags.FlagHeight, -1, "Export at this height, defaulting to the latest height")
+	addExportFlags(cmd)
+}
+
+// loadExportApp loads the app of the node home at the height flag. The returned db must be
+// closed once the export is done.
+func loadExportApp(cmd *cobra.Command, a appCreator) (*terraapp.TerraApp, dbm.DB, error) {
+	serverCtx := server.GetServerContextFromCmd(cmd)
+	homeDir, _ := cmd.Flags().GetString(flags.FlagHome)
+	height, _ := cmd.Flags().GetInt64(flags.FlagHeight)
+	if err := applyExportFlags(serverCtx.Viper); err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sdk.NewLevelDB("application", filepath.Join(homeDir, "data"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	terraApp := terraapp.NewTerraApp(serverCtx.Logger, db, nil, height == -1, map[int64]bool{}, homeDir, 0, a.encodingConfig, serverCtx.Viper, wasmconfig.DefaultConfig())
+	if height != -1 {
+		if err := terraApp.LoadHeight(height); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+	}
+	return terraApp, db, nil
+}
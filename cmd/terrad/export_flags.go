@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	servertypes "github.com/cosmos/cosmos-sdk/server/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+
+	"github.com/terra-money/core/app/export/anchor"
+	"github.com/terra-money/core/app/export/aperture"
+	"github.com/terra-money/core/app/export/apollo"
+	"github.com/terra-money/core/app/export/mars"
+)
+
+// exporter options, shared by the export command and the standalone export commands
+const (
+	flagApolloSampleSize             = "apollo-sample-size"
+	flagApertureWorkers              = "aperture-workers"
+	flagApertureAllowFailedPositions = "aperture-allow-failed-positions"
+	flagApertureRetries              = "aperture-retries"
+	flagMarsSafetyFundBeneficiary    = "mars-safety-fund-beneficiary"
+	flagAnchorNetBorrowers           = "anchor-net-borrowers"
+	flagAnchorLunaPrice              = "anchor-luna-price"
+)
+
+// addExportFlags adds the exporter options read by applyExportFlags
+func addExportFlags(cmd *cobra.Command) {
+	cmd.Flags().Int(flagApolloSampleSize, apollo.VaultRewardsSampleSize, "Number of decoded Apollo pending rewards checked with smart queries")
+	cmd.Flags().Int(flagApertureWorkers, aperture.WorkerCount, "Number of goroutines querying Aperture positions")
+	cmd.Flags().Bool(flagApertureAllowFailedPositions, aperture.AllowFailedPositions, "Export without Aperture positions whose query fails, listing them in aperture-failed.csv")
+	cmd.Flags().Int(flagApertureRetries, aperture.MaxRetries, "Number of times failed Aperture positions are queried again")
+	cmd.Flags().String(flagMarsSafetyFundBeneficiary, mars.SafetyFundBeneficiary, "Address receiving the Mars safety fund, defaulting to the safety fund admin")
+	cmd.Flags().Bool(flagAnchorNetBorrowers, anchor.NetBorrowerPositions, "Subtract outstanding Anchor loans from the bLUNA collateral credit")
+	cmd.Flags().String(flagAnchorLunaPrice, "", "LUNA price in uusd used to value Anchor loans, defaulting to the oracle exchange rate")
+}
+
+// applyExportFlags sets the exporter options from the command flags, options whose flag
+// is not registered keep their default
+func applyExportFlags(appOpts servertypes.AppOptions) error {
+	if v := appOpts.Get(flagApolloSampleSize); v != nil {
+		apollo.VaultRewardsSampleSize = cast.ToInt(v)
+	}
+	if v := appOpts.Get(flagApertureWorkers); v != nil {
+		aperture.WorkerCount = cast.ToInt(v)
+	}
+	if v := appOpts.Get(flagApertureAllowFailedPositions); v != nil {
+		aperture.AllowFailedPositions = cast.ToBool(v)
+	}
+	if v := appOpts.Get(flagApertureRetries); v != nil {
+		aperture.MaxRetries = cast.ToInt(v)
+	}
+	if v := appOpts.Get(flagMarsSafetyFundBeneficiary); v != nil {
+		mars.SafetyFundBeneficiary = cast.ToString(v)
+	}
+	if v := appOpts.Get(flagAnchorNetBorrowers); v != nil {
+		anchor.NetBorrowerPositions = cast.ToBool(v)
+	}
+	if v := cast.ToString(appOpts.Get(flagAnchorLunaPrice)); v != "" {
+		price, err := sdk.NewDecFromStr(v)
+		if err != nil || !price.IsPositive() {
+			return fmt.Errorf("invalid %s %s", flagAnchorLunaPrice, v)
+		}
+		anchor.LunaPrice = &price
+	}
+	return nil
+}
+
+// findCommand returns the direct subcommand of cmd with the given name
+func findCommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, c := range cmd.Commands() {
+		if c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
@@ -117,6 +117,10 @@ func initRootCmd(rootCmd *cobra.Command, encodingConfig params.EncodingConfig) {
 
 	a := appCreator{encodingConfig}
 	server.AddCommands(rootCmd, terraapp.DefaultNodeHome, a.newApp, a.appExport, addModuleInitFlags)
+	if exportCmd := findCommand(rootCmd, "export"); exportCmd != nil {
+		addExportFlags(exportCmd)
+	}
+	rootCmd.AddCommand(apolloTimelineCmd(a), apolloPositionsCmd(a), astroGeneratorRewardsCmd(a))
 
 	// add keybase, auxiliary RPC, query, and tx child commands
 	rootCmd.AddCommand(
@@ -313,6 +317,9 @@ func (a appCreator) appExport(
 	if !ok || homePath == "" {
 		return servertypes.ExportedApp{}, errors.New("application home not set")
 	}
+	if err := applyExportFlags(appOpts); err != nil {
+		return servertypes.ExportedApp{}, err
+	}
 
 	var terraApp *terraapp.TerraApp
 	if height != -1 {